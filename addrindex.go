@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+	"sort"
+)
+
+const addrIndexBucket = "addrindex"
+
+const (
+	AddrReceived = iota
+	AddrSent
+)
+
+// AddressEntry records one output paid to or spent from an address.
+// OutTxid and OutIndex identify the output; Txid is the transaction that
+// created it (AddrReceived) or spent it (AddrSent).
+type AddressEntry struct {
+	Txid      []byte
+	OutTxid   []byte
+	OutIndex  int
+	Height    int
+	Direction int
+	Value     int
+}
+
+type AddressEntries struct {
+	Entries []AddressEntry
+}
+
+func (entries AddressEntries) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(entries)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+func DeserializeAddressEntries(data []byte) AddressEntries {
+	var entries AddressEntries
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&entries)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return entries
+}
+
+// addressKey returns the key outputs locked by script are indexed under:
+// the public key hash of pay-to-pubkey-hash scripts and the whole script of
+// multisig and pay-to-script-hash ones. Outputs with other scripts are not
+// indexed.
+func addressKey(script []byte) (string, bool) {
+	if pubKeyHash, ok := extractPubKeyHash(script); ok {
+		return string(pubKeyHash), true
+	}
+	if _, _, ok := extractMultisig(script); ok {
+		return string(script), true
+	}
+	if _, ok := extractScriptHash(script); ok {
+		return string(script), true
+	}
+	return "", false
+}
+
+// indexAddresses appends the entries produced by block to the address
+// index. It must run after indexTransactions so that outputs created
+// earlier in the same block can be looked up.
+func indexAddresses(tx StoreTx, block *Block) error {
+	newEntries := make(map[string][]AddressEntry)
+
+	for _, t := range block.Transactions {
+		if t.IsCoinbase() == false {
+			for _, vin := range t.Vin {
+				out, err := findOutput(tx, vin.Txid, vin.Vout)
+				if err != nil {
+					return err
+				}
+				key, ok := addressKey(out.ScriptPubKey)
+				if !ok {
+					continue
+				}
+				entry := AddressEntry{t.ID, vin.Txid, vin.Vout, block.Height, AddrSent, out.Value}
+				newEntries[key] = append(newEntries[key], entry)
+			}
+		}
+
+		for outIdx, out := range t.Vout {
+			key, ok := addressKey(out.ScriptPubKey)
+			if !ok {
+				continue
+			}
+			entry := AddressEntry{t.ID, t.ID, outIdx, block.Height, AddrReceived, out.Value}
+			newEntries[key] = append(newEntries[key], entry)
+		}
+	}
+
+	for pubKeyHash, added := range newEntries {
+		var entries AddressEntries
+		if data := tx.Get(addrIndexBucket, []byte(pubKeyHash)); data != nil {
+			entries = DeserializeAddressEntries(data)
+		}
+		entries.Entries = append(entries.Entries, added...)
+
+		err := tx.Put(addrIndexBucket, []byte(pubKeyHash), entries.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexAddresses removes the entries added by indexAddresses for block.
+// It must run while the transactions of block are still indexed.
+func unindexAddresses(tx StoreTx, block *Block) error {
+	txIDs := make(map[string]bool)
+	pubKeyHashes := make(map[string]bool)
+
+	for _, t := range block.Transactions {
+		txIDs[string(t.ID)] = true
+
+		if t.IsCoinbase() == false {
+			for _, vin := range t.Vin {
+				out, err := findOutput(tx, vin.Txid, vin.Vout)
+				if err != nil {
+					return err
+				}
+				if key, ok := addressKey(out.ScriptPubKey); ok {
+					pubKeyHashes[key] = true
+				}
+			}
+		}
+		for _, out := range t.Vout {
+			if key, ok := addressKey(out.ScriptPubKey); ok {
+				pubKeyHashes[key] = true
+			}
+		}
+	}
+
+	for pubKeyHash := range pubKeyHashes {
+		data := tx.Get(addrIndexBucket, []byte(pubKeyHash))
+		if data == nil {
+			continue
+		}
+		entries := DeserializeAddressEntries(data)
+
+		var kept AddressEntries
+		for _, entry := range entries.Entries {
+			if entry.Height == block.Height && txIDs[string(entry.Txid)] {
+				continue
+			}
+			kept.Entries = append(kept.Entries, entry)
+		}
+
+		var err error
+		if len(kept.Entries) == 0 {
+			err = tx.Delete(addrIndexBucket, []byte(pubKeyHash))
+		} else {
+			err = tx.Put(addrIndexBucket, []byte(pubKeyHash), kept.Serialize())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findOutput reads an output of an already indexed transaction
+func findOutput(tx StoreTx, txid []byte, vout int) (TXOutput, error) {
+	prevTx, _, err := findTransaction(tx, txid)
+	if err != nil {
+		return TXOutput{}, err
+	}
+	if vout < 0 || vout >= len(prevTx.Vout) {
+		return TXOutput{}, errors.New("Output index is out of range")
+	}
+
+	return prevTx.Vout[vout], nil
+}
+
+// ReindexAddresses drops the address index and rebuilds it from the whole
+// chain. The transaction index has to be complete beforehand.
+func (bc *Blockchain) ReindexAddresses() {
+	err := bc.store.Batch(func(tx StoreTx) error {
+		err := tx.DropBucket(addrIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		return walkChain(tx, func(block *Block) error {
+			return indexAddresses(tx, block)
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// AddressHistory returns every entry indexed under key, see addressKey,
+// ordered by height
+func (bc *Blockchain) AddressHistory(key []byte) []AddressEntry {
+	var entries AddressEntries
+
+	err := bc.store.View(func(tx StoreTx) error {
+		data := tx.Get(addrIndexBucket, key)
+		if data != nil {
+			entries = DeserializeAddressEntries(data)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	sort.SliceStable(entries.Entries, func(i, j int) bool {
+		return entries.Entries[i].Height < entries.Entries[j].Height
+	})
+
+	return entries.Entries
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+	cases := []struct {
+		decoded string
+		encoded string
+	}{
+		{"", ""},
+		{"00", "1"},
+		{"000001", "112"},
+		{"68656c6c6f20776f726c64", "StV1DL6CwTryKyV"},
+		{"00000000287fb4cd", "1111233QC4"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	}
+
+	for _, c := range cases {
+		decoded, _ := hex.DecodeString(c.decoded)
+		if got := string(Base58Encode(decoded)); got != c.encoded {
+			t.Errorf("%s encodes to %s, want %s", c.decoded, got, c.encoded)
+		}
+		if got := Base58Decode([]byte(c.encoded)); !bytes.Equal(got, decoded) {
+			t.Errorf("%s decodes to %x, want %s", c.encoded, got, c.decoded)
+		}
+	}
+}
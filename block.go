@@ -4,20 +4,95 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/gob"
-	"fmt"
 	"log"
-	"os"
 	"strconv"
-	"time"
 )
 
+// blockVersion is the header version of newly mined blocks. Version 0
+// headers predate it and hash without their version and height.
+const blockVersion = 2
+
+// legacyTargetBits is the difficulty blocks were mined at before they
+// carried their Bits
+const legacyTargetBits = 22
+
+// coinbaseHeightVersion is the first block version whose coinbase has to
+// start its input data with the block height
+const coinbaseHeightVersion = 2
+
+// BlockHeader holds everything the proof of work commits to. Headers are
+// stored apart from the transactions, so the chain can be followed and
+// checked without reading block bodies.
+type BlockHeader struct {
+	Version       int
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          int
+	Nonce         int
+	Height        int
+}
+
 type Block struct {
+	BlockHeader
+	Hash         []byte
+	Transactions []*Transaction
+}
+
+// blockBody is the gob layout of the part of a block stored under the
+// blocks bucket before the binary encoding
+type blockBody struct {
+	Transactions []*gobTransaction
+}
+
+// gobTransaction is the layout of transactions in gob encoded blocks. They
+// all predate scripts and the transaction version.
+type gobTransaction struct {
+	ID       []byte
+	Vin      []gobTXInput
+	Vout     []gobTXOutput
+	LockTime int64
+}
+
+type gobTXInput struct {
+	Txid      []byte
+	Vout      int
+	Signature []byte
+	PubKey    []byte
+	Sequence  uint32
+}
+
+type gobTXOutput struct {
+	Value      int
+	PubKeyHash []byte
+}
+
+func gobTransactions(gobTxs []*gobTransaction) []*Transaction {
+	var txs []*Transaction
+	for _, gobTx := range gobTxs {
+		tx := &Transaction{ID: gobTx.ID, LockTime: gobTx.LockTime}
+		for _, vin := range gobTx.Vin {
+			scriptSig := payToPubKeyHashScriptSig(vin.Signature, vin.PubKey)
+			tx.Vin = append(tx.Vin, TXInput{vin.Txid, vin.Vout, scriptSig, vin.Sequence})
+		}
+		for _, vout := range gobTx.Vout {
+			tx.Vout = append(tx.Vout, TXOutput{vout.Value, payToPubKeyHashScript(vout.PubKeyHash)})
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// legacyBlock is the layout of blocks written before headers were split
+// out of them
+type legacyBlock struct {
 	Timestamp     int64
-	Transactions  []*Transaction
+	Transactions  []*gobTransaction
 	PrevBlockHash []byte
 	Hash          []byte
 	Nonce         int
 	Height        int
+	Bits          int
 }
 
 func (b *Block) setHash() {
@@ -27,30 +102,110 @@ func (b *Block) setHash() {
 	b.Hash = hash[:]
 }
 
+// Serialization returns the binary encoding of the block: its header
+// followed by its transactions
 func (b *Block) Serialization() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-	err := encoder.Encode(b)
-	if err != nil {
-		fmt.Printf("Error to serialize block:%x.\n", b.Hash)
-		os.Exit(1)
+	buf := b.BlockHeader.appendTo(nil)
+	buf = appendVarint(buf, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		buf = tx.appendTo(buf)
+	}
+	return buf
+}
+
+// Size is the length of the serialized block, which maxBlockSize limits
+func (b *Block) Size() int {
+	return len(b.Serialization())
+}
+
+// serializeBody encodes the transactions of the block for storage. Their
+// IDs are stored along with them, as the IDs of version 0 transactions can
+// not be computed from the binary encoding.
+func (b *Block) serializeBody() []byte {
+	buf := appendVarint(nil, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		buf = appendBytes(buf, tx.ID)
+		buf = tx.appendTo(buf)
 	}
-	return result.Bytes()
+	return buf
 }
 
+// HashTransaction returns the Merkle root of the transaction IDs
 func (b *Block) HashTransaction() []byte {
-	var txHashes [][]byte
-	var txHash [32]byte
+	return b.MerkleTree().Root()
+}
+
+func (b *Block) MerkleTree() *MerkleTree {
+	var txIDs [][]byte
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID)
+		txIDs = append(txIDs, tx.ID)
+	}
+	return NewMerkleTree(txIDs)
+}
+
+func (h *BlockHeader) Serialize() []byte {
+	return h.appendTo(nil)
+}
+
+func (h *BlockHeader) appendTo(buf []byte) []byte {
+	buf = appendUint32(buf, uint32(h.Version))
+	buf = appendBytes(buf, h.PrevBlockHash)
+	buf = appendBytes(buf, h.MerkleRoot)
+	buf = appendUint64(buf, uint64(h.Timestamp))
+	buf = appendUint32(buf, uint32(h.Bits))
+	buf = appendUint64(buf, uint64(h.Nonce))
+	return appendUint32(buf, uint32(h.Height))
+}
+
+func readBlockHeader(d *decoder) *BlockHeader {
+	return &BlockHeader{
+		Version:       int(d.uint32()),
+		PrevBlockHash: d.bytes(),
+		MerkleRoot:    d.bytes(),
+		Timestamp:     int64(d.uint64()),
+		Bits:          int(d.uint32()),
+		Nonce:         int(d.uint64()),
+		Height:        int(d.uint32()),
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
-	return txHash[:]
 }
 
-func NewBlock(txs []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), txs, prevBlockHash, []byte{}, 0, height}
-	pow := NewProofOfWork(block)
+// Hash returns the hash the proof of work is computed over
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.hashData(h.Nonce))
+	return hash[:]
+}
+
+func (h *BlockHeader) hashData(nonce int) []byte {
+	fields := [][]byte{
+		h.PrevBlockHash,
+		h.MerkleRoot,
+		IntToHex(h.Timestamp),
+		IntToHex(int64(h.Bits)),
+		IntToHex(int64(nonce)),
+	}
+	if h.Version > 0 {
+		fields = append([][]byte{IntToHex(int64(h.Version))}, fields...)
+		fields = append(fields, IntToHex(int64(h.Height)))
+	}
+
+	return bytes.Join(fields, []byte{})
+}
+
+func NewBlock(txs []*Transaction, prevBlockHash []byte, height int, bits int) *Block {
+	return newBlockAt(txs, prevBlockHash, height, bits, timeNow().Unix())
+}
+
+func newBlockAt(txs []*Transaction, prevBlockHash []byte, height int, bits int, timestamp int64) *Block {
+	block := &Block{Transactions: txs}
+	block.BlockHeader = BlockHeader{
+		Version:       blockVersion,
+		PrevBlockHash: prevBlockHash,
+		MerkleRoot:    block.HashTransaction(),
+		Timestamp:     timestamp,
+		Bits:          bits,
+		Height:        height,
+	}
+	pow := NewProofOfWork(&block.BlockHeader)
 	nonce, hash := pow.Solve()
 	block.Nonce = nonce
 	block.Hash = hash[:]
@@ -58,15 +213,81 @@ func NewBlock(txs []*Transaction, prevBlockHash []byte, height int) *Block {
 }
 
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, params.GenesisBits)
+}
+
+func DeserializeBlockHeader(data []byte) *BlockHeader {
+	d := decoder{data: data}
+	header := readBlockHeader(&d)
+	err := d.finish()
+	if err != nil {
+		log.Panic(err)
+	}
+	return header
+}
+
+func deserializeBlockBody(data []byte) []*Transaction {
+	var txs []*Transaction
+
+	d := decoder{data: data}
+	for i := d.count(); i > 0 && d.err == nil; i-- {
+		id := d.bytes()
+		tx := readTransaction(&d)
+		tx.ID = id
+		txs = append(txs, tx)
+	}
+	err := d.finish()
+	if err != nil {
+		log.Panic(err)
+	}
+	return txs
+}
+
+// deserializeGobHeader reads a header stored before the binary encoding
+func deserializeGobHeader(d []byte) *BlockHeader {
+	var header BlockHeader
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&header)
+	if err != nil {
+		log.Panic(err)
+	}
+	return &header
 }
 
-func DeserializeBlock(d []byte) *Block {
-	var block Block
+// deserializeGobBody reads a block body stored before the binary encoding
+func deserializeGobBody(d []byte) []*Transaction {
+	var body blockBody
 	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+	err := decoder.Decode(&body)
 	if err != nil {
 		log.Panic(err)
 	}
-	return &block
+	return gobTransactions(body.Transactions)
+}
+
+// deserializeLegacyBlock reads a block written before headers were split
+// out of it
+func deserializeLegacyBlock(d []byte) *Block {
+	var legacy legacyBlock
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&legacy)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	block := &Block{Hash: legacy.Hash, Transactions: gobTransactions(legacy.Transactions)}
+	block.BlockHeader = BlockHeader{
+		Version:       0,
+		PrevBlockHash: legacy.PrevBlockHash,
+		MerkleRoot:    block.HashTransaction(),
+		Timestamp:     legacy.Timestamp,
+		Bits:          legacy.Bits,
+		Nonce:         legacy.Nonce,
+		Height:        legacy.Height,
+	}
+	// blocks stored before Bits existed were all mined at legacyTargetBits
+	if block.Bits == 0 {
+		block.Bits = legacyTargetBits
+	}
+	return block
 }
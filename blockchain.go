@@ -1,192 +1,330 @@
 package main
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"log"
 	"os"
-
-	"github.com/boltdb/bolt"
 )
 
 type Blockchain struct {
-	tip []byte
-	db  *bolt.DB
+	tip   []byte
+	store ChainStore
 }
 
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
+// blockSizeReserve is the room MineBlock leaves for the header and the
+// coinbase when it packs transactions
+const blockSizeReserve = 1000
+
+// MineBlock mines a block with transactions on top of the tip. The block's
+// coinbase pays the block subsidy plus the fees of transactions to
+// minerAddress. Transactions are packed in order until the next one would
+// not fit in maxBlockSize; the rest are left out of the block.
+func (bc *Blockchain) MineBlock(minerAddress string, transactions []*Transaction) *Block {
 	var lastHash []byte
 	var lastHeight int
+	var bits int
+	var fees int
+	var timestamp int64
+	var included []*Transaction
+
+	err := bc.store.View(func(tx StoreTx) error {
+		lastHash = getTip(tx)
+
+		header, err := getHeader(tx, lastHash)
+		if err != nil {
+			return err
+		}
+		lastHeight = header.Height
 
-	for _, tx := range transactions {
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("Error: Invalid transaction.")
+		// the clock may lag behind blocks mined in quick succession
+		mtp, err := medianTimePast(tx, header)
+		if err != nil {
+			return err
+		}
+		timestamp = timeNow().Unix()
+		if timestamp <= mtp {
+			timestamp = mtp + 1
 		}
-	}
 
-	err := bc.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		view := newUTXOView(tx, lastHeight+1, mtp)
+		blockSize := blockSizeReserve
+		for _, t := range transactions {
+			if t.IsCoinbase() {
+				return fmt.Errorf("%w: transaction %x", ErrMultipleCoinbase, t.ID)
+			}
 
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
-		lastHeight = block.Height
-		return nil
+			size := t.Size()
+			if size > maxTxSize {
+				return fmt.Errorf("%w: transaction %x has %d bytes", ErrTxTooLarge, t.ID, size)
+			}
+			if blockSize+size > maxBlockSize {
+				break
+			}
+
+			fee, err := checkTransactionInputs(view, t)
+			if err != nil {
+				return err
+			}
+			fees += fee
+			blockSize += size
+			included = append(included, t)
+			view.spend(t)
+			view.add(t)
+		}
+
+		bits, err = nextBits(tx, header)
+		return err
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	cbTx := NewCoinbaseTX(minerAddress, "", lastHeight+1, blockSubsidy(lastHeight+1)+fees)
+	transactions = append([]*Transaction{cbTx}, included...)
+	newBlock := newBlockAt(transactions, lastHash, lastHeight+1, bits, timestamp)
+
+	err = bc.AddBlock(newBlock)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return newBlock
+}
 
-	err = bc.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialization())
+// AddBlock validates and stores block. Blocks that come from outside go
+// through here as well as the ones mined locally. A block may extend any
+// known block; it is kept on a side chain until its branch carries more
+// cumulative work than the active chain, at which point the chain is
+// reorganized onto it. A block that is already stored goes through the same
+// checks and is stored again, since a side chain body is stored before its
+// transactions are validated.
+func (bc *Blockchain) AddBlock(block *Block) error {
+	err := bc.store.Batch(func(tx StoreTx) error {
+		err := checkBlockSanity(block)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		err = b.Put([]byte("l"), newBlock.Hash)
+		err = checkHeader(tx, &block.BlockHeader)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		bc.tip = newBlock.Hash
+		work := getWork(tx, block.PrevBlockHash)
+		work.Add(work, blockWork(block.Bits))
 
-		return nil
+		err = putBlock(tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = putWork(tx, block.Hash, work)
+		if err != nil {
+			return err
+		}
+
+		if work.Cmp(getWork(tx, getTip(tx))) <= 0 {
+			return nil
+		}
+		return reorganize(tx, block)
 	})
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	return newBlock
+	bc.tip, err = bc.store.GetTip()
+	return err
 }
 
+// VerifyTransaction reports whether tx passes CheckTransaction
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
 	if tx.IsCoinbase() {
 		return true
 	}
 
-	prevTXs := make(map[string]Transaction)
+	return bc.CheckTransaction(tx) == nil
+}
 
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
+// FindTransaction looks a transaction up in the transaction index and
+// returns it together with the block hash and height it was mined at
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, TxLocation, error) {
+	var found *Transaction
+	var loc TxLocation
+
+	err := bc.store.View(func(tx StoreTx) error {
+		var err error
+		found, loc, err = findTransaction(tx, ID)
+		return err
+	})
+	if err != nil {
+		return Transaction{}, TxLocation{}, err
+	}
+
+	return *found, loc, nil
+}
+
+// TransactionFee returns the inputs minus the outputs of a non-coinbase
+// transaction. The spent outputs are looked up in the transaction index, so
+// it works for transactions that are already in the chain too.
+func (bc *Blockchain) TransactionFee(t *Transaction) (int, error) {
+	if t.IsCoinbase() {
+		return 0, nil
+	}
+
+	fee := 0
+	for _, vin := range t.Vin {
+		prevTx, _, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return 0, err
+		}
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return 0, fmt.Errorf("%w: %x:%d", ErrMissingInput, vin.Txid, vin.Vout)
 		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+		fee += prevTx.Vout[vin.Vout].Value
 	}
-	return tx.Verify(prevTXs)
+	for _, out := range t.Vout {
+		fee -= out.Value
+	}
+	return fee, nil
 }
 
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
-	for {
-		block := bci.Next()
+// connectBlock updates the UTXO set and the indexes for a block that has
+// just become the new tip. It runs inside the caller's store transaction.
+func connectBlock(tx StoreTx, block *Block) error {
+	err := updateUTXO(tx, block)
+	if err != nil {
+		return err
+	}
 
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
+	err = indexTransactions(tx, block)
+	if err != nil {
+		return err
+	}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	err = indexAddresses(tx, block)
+	if err != nil {
+		return err
 	}
-	return Transaction{}, errors.New("Transaction is not found")
+
+	return indexHeight(tx, block)
 }
 
 func NewBlockchain(address string) *Blockchain {
+	store, err := NewBoltStore(params.path(dbFile))
+	if err != nil {
+		log.Panic(err)
+	}
+	return LoadBlockchain(store)
+}
+
+// LoadBlockchain opens the chain kept in store, building any index the
+// store does not have yet
+func LoadBlockchain(store ChainStore) *Blockchain {
 	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+	var version int
+	var hasHeaders, hasUTXO, hasTxIndex, hasHeightIndex, hasAddrIndex, hasWork bool
+	err := store.View(func(tx StoreTx) error {
+		tip = getTip(tx)
+		version = getStoreVersion(tx)
+		hasHeaders = tx.HasBucket(headersBucket)
+		hasUTXO = tx.HasBucket(utxoBucket)
+		hasTxIndex = tx.HasBucket(txIndexBucket)
+		hasHeightIndex = tx.HasBucket(heightIndexBucket)
+		hasAddrIndex = tx.HasBucket(addrIndexBucket)
+		hasWork = tx.HasBucket(workBucket)
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	bc := Blockchain{tip, db}
-	return &bc
-}
+	bc := Blockchain{tip, store}
 
-func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOutputs := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransaction(pubKeyHash)
-	accumulated := 0
-Work:
-	for _, tx := range unspentTXs {
-		txID := hex.EncodeToString(tx.ID)
-
-		for outIdx, out := range tx.Vout {
-			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated += out.Value
-				unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
-
-				if accumulated >= amount {
-					break Work
-				}
-			}
+	// databases created before these buckets existed get them built once
+	if !hasHeaders && len(tip) > 0 {
+		err = store.Batch(migrateLegacyBlocks)
+		if err != nil {
+			log.Panic(err)
+		}
+	} else if version < 1 && len(tip) > 0 {
+		err = store.Batch(migrateGobBlocks)
+		if err != nil {
+			log.Panic(err)
 		}
 	}
-	return accumulated, unspentOutputs
-}
-
-func (bc *Blockchain) FindUTXO(pubKeyHash []byte) []TXOutput {
-	var UTXOs []TXOutput
-	unspentTransactions := bc.FindUnspentTransaction(pubKeyHash)
-	for _, tx := range unspentTransactions {
-		for _, out := range tx.Vout {
-			if out.IsLockedWithKey(pubKeyHash) {
-				UTXOs = append(UTXOs, out)
-			}
+	// outputs in the UTXO set had a public key hash instead of a script
+	if version < 2 {
+		hasUTXO = false
+	}
+	if !hasUTXO {
+		UTXOSet{&bc}.Reindex()
+	}
+	if !hasTxIndex {
+		bc.ReindexTransactions()
+	}
+	if !hasHeightIndex {
+		bc.ReindexHeights()
+	}
+	if !hasAddrIndex {
+		bc.ReindexAddresses()
+	}
+	if !hasWork {
+		bc.ReindexWork()
+	}
+	if version < storeVersion {
+		err = store.Batch(setStoreVersion)
+		if err != nil {
+			log.Panic(err)
 		}
 	}
-	return UTXOs
+	return &bc
 }
 
-func (bc *Blockchain) FindUnspentTransaction(pubKeyHash []byte) []Transaction {
-	var unspentTXs []Transaction
-	spentTxo := make(map[string][]int)
+// FindUTXO scans the whole chain and returns every unspent output keyed by
+// transaction ID. It is only used to rebuild the utxo bucket.
+func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
+	UTXO := make(map[string]TXOutputs)
+	spentTXOs := make(map[string][]int)
 	bci := bc.Iterator()
+
 	for {
 		block := bci.Next()
-		for _, tx := range block.Transactions {
+
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			tx := block.Transactions[i]
 			txID := hex.EncodeToString(tx.ID)
 
 		Outputs:
 			for outIdx, out := range tx.Vout {
-				if spentTxo[txID] != nil {
-					for _, spentOut := range spentTxo[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
+				for _, spentOutIdx := range spentTXOs[txID] {
+					if spentOutIdx == outIdx {
+						continue Outputs
 					}
 				}
-				if out.IsLockedWithKey(pubKeyHash) {
-					unspentTXs = append(unspentTXs, *tx)
+
+				outs, ok := UTXO[txID]
+				if !ok {
+					outs = TXOutputs{make(map[int]TXOutput), block.Height, tx.IsCoinbase()}
+					UTXO[txID] = outs
 				}
+				outs.Outputs[outIdx] = out
 			}
+
 			if tx.IsCoinbase() == false {
 				for _, in := range tx.Vin {
-					if in.UsesKey(pubKeyHash) {
-						inTxID := hex.EncodeToString(in.Txid)
-						spentTxo[inTxID] = append(spentTxo[inTxID], in.Vout)
-					}
+					inTxID := hex.EncodeToString(in.Txid)
+					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
 				}
 			}
 		}
+
 		if len(block.PrevBlockHash) == 0 {
 			break
 		}
 	}
 
-	return unspentTXs
+	return UTXO
 }
 
 func CreateBlockchain(address string) *Blockchain {
@@ -195,53 +333,54 @@ func CreateBlockchain(address string) *Blockchain {
 		os.Exit(1)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := NewBoltStore(params.path(dbFile))
 	if err != nil {
 		log.Panic(err)
 	}
+	return CreateBlockchainInStore(store, address)
+}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-		genesis := NewGenesisBlock(cbtx)
-
-		b, err := tx.CreateBucket([]byte(blocksBucket))
-		if err != nil {
-			log.Panic(err)
-		}
-
-		err = b.Put(genesis.Hash, genesis.Serialization())
-		if err != nil {
-			log.Panic(err)
-		}
-
-		err = b.Put([]byte("l"), genesis.Hash)
-		if err != nil {
-			log.Panic(err)
-		}
-		tip = genesis.Hash
-
-		return nil
-	})
+// CreateBlockchainInStore mines a genesis block paying to address and
+// writes it to an empty store
+func CreateBlockchainInStore(store ChainStore, address string) *Blockchain {
+	cbtx := NewCoinbaseTX(address, params.GenesisCoinbaseData, 0, blockSubsidy(0))
+	genesis := NewGenesisBlock(cbtx)
 
+	err := store.Batch(setStoreVersion)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{nil, store}
+	err = bc.AddBlock(genesis)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	return &bc
 }
 
 func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+	tx.Sign(privKey, bc.prevTransactions(tx))
+}
+
+// SignMultisigTransaction adds the signature of privKey to the inputs of tx
+// spending multisig outputs, see Transaction.SignMultisig
+func (bc *Blockchain) SignMultisigTransaction(tx *Transaction, privKey ecdsa.PrivateKey) int {
+	return tx.SignMultisig(privKey, bc.prevTransactions(tx))
+}
+
+// prevTransactions returns the transactions spent by tx keyed by hex
+// encoded ID
+func (bc *Blockchain) prevTransactions(tx *Transaction) map[string]Transaction {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
-		prevTx, err := bc.FindTransaction(vin.Txid)
+		prevTx, _, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
 			log.Panic(err)
 		}
 		prevTXs[hex.EncodeToString(prevTx.ID)] = prevTx
 	}
-	tx.Sign(privKey, prevTXs)
+	return prevTXs
 }
@@ -1,31 +1,23 @@
 package main
 
 import (
-	"fmt"
+	"log"
 	"os"
-
-	"github.com/boltdb/bolt"
 )
 
 type BlockchainIterator struct {
 	currentBlockHash []byte
-	db               *bolt.DB
+	store            ChainStore
 }
 
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{bc.tip, bc.db}
+	return &BlockchainIterator{bc.tip, bc.store}
 }
 
 func (it *BlockchainIterator) Next() *Block {
-	var block *Block
-	err := it.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockHash := b.Get(it.currentBlockHash)
-		block = DeserializeBlock(blockHash)
-		return nil
-	})
+	block, err := it.store.GetBlock(it.currentBlockHash)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error with bolt db file", err)
+		log.Panic(err)
 	}
 
 	it.currentBlockHash = block.PrevBlockHash
@@ -34,7 +26,7 @@ func (it *BlockchainIterator) Next() *Block {
 }
 
 func dbExists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+	if _, err := os.Stat(params.path(dbFile)); os.IsNotExist(err) {
 		return false
 	}
 
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// BoltStore keeps the chain in a bolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+type boltStoreTx struct {
+	tx *bolt.Tx
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db}, nil
+}
+
+func (s *BoltStore) GetHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+	err := s.View(func(tx StoreTx) error {
+		var err error
+		header, err = getHeader(tx, hash)
+		return err
+	})
+	return header, err
+}
+
+func (s *BoltStore) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+	err := s.View(func(tx StoreTx) error {
+		var err error
+		block, err = getBlock(tx, hash)
+		return err
+	})
+	return block, err
+}
+
+func (s *BoltStore) GetTip() ([]byte, error) {
+	var tip []byte
+	err := s.View(func(tx StoreTx) error {
+		tip = getTip(tx)
+		return nil
+	})
+	return tip, err
+}
+
+func (s *BoltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltStoreTx{tx})
+	})
+}
+
+func (s *BoltStore) Batch(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltStoreTx{tx})
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t boltStoreTx) Get(bucket string, key []byte) []byte {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Get(key)
+}
+
+func (t boltStoreTx) Put(bucket string, key, value []byte) error {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+func (t boltStoreTx) Delete(bucket string, key []byte) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete(key)
+}
+
+func (t boltStoreTx) ForEach(bucket string, fn func(k, v []byte) error) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(fn)
+}
+
+func (t boltStoreTx) HasBucket(bucket string) bool {
+	return t.tx.Bucket([]byte(bucket)) != nil
+}
+
+func (t boltStoreTx) DropBucket(bucket string) error {
+	err := t.tx.DeleteBucket([]byte(bucket))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
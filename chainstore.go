@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const tipKey = "l"
+const headersBucket = "headers"
+
+// storeVersion is the layout of the stored data, kept under storeVersionKey
+// in the meta bucket. Stores without it hold headers and block bodies gob
+// encoded; version 1 uses the binary encoding of encoding.go and version 2
+// has outputs with scripts in the UTXO set.
+const storeVersion = 2
+const metaBucket = "meta"
+const storeVersionKey = "version"
+
+// ChainStore is the storage backend of a Blockchain. Block headers live in
+// the headers bucket and block transactions in the blocks bucket, both
+// keyed by block hash, and the tip hash is kept under tipKey in the blocks
+// bucket; the UTXO set and the indexes use their own buckets through
+// StoreTx. Blocks and the tip are written through StoreTx as well, so that
+// they change together with the UTXO set and the indexes.
+type ChainStore interface {
+	GetHeader(hash []byte) (*BlockHeader, error)
+	GetBlock(hash []byte) (*Block, error)
+	GetTip() ([]byte, error)
+
+	// View runs fn in a read-only transaction
+	View(fn func(tx StoreTx) error) error
+	// Batch runs fn in a read-write transaction. Nothing is written
+	// unless fn returns nil.
+	Batch(fn func(tx StoreTx) error) error
+
+	Close() error
+}
+
+// StoreTx gives access to the buckets of a ChainStore within a transaction.
+// Reading or deleting from a missing bucket is not an error and Put creates
+// the bucket on demand.
+type StoreTx interface {
+	Get(bucket string, key []byte) []byte
+	Put(bucket string, key, value []byte) error
+	Delete(bucket string, key []byte) error
+	// ForEach calls fn for every key of bucket in ascending order and
+	// stops at the first error, which it returns
+	ForEach(bucket string, fn func(k, v []byte) error) error
+	HasBucket(bucket string) bool
+	DropBucket(bucket string) error
+}
+
+var errBlockNotFound = errors.New("Block is not found")
+
+func getHeader(tx StoreTx, hash []byte) (*BlockHeader, error) {
+	headerData := tx.Get(headersBucket, hash)
+	if headerData == nil {
+		return nil, errBlockNotFound
+	}
+	return DeserializeBlockHeader(headerData), nil
+}
+
+func getBlock(tx StoreTx, hash []byte) (*Block, error) {
+	header, err := getHeader(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyData := tx.Get(blocksBucket, hash)
+	if bodyData == nil {
+		return nil, errBlockNotFound
+	}
+
+	block := &Block{*header, append([]byte{}, hash...), deserializeBlockBody(bodyData)}
+	return block, nil
+}
+
+func putBlock(tx StoreTx, block *Block) error {
+	err := tx.Put(headersBucket, block.Hash, block.BlockHeader.Serialize())
+	if err != nil {
+		return err
+	}
+	return tx.Put(blocksBucket, block.Hash, block.serializeBody())
+}
+
+// migrateLegacyBlocks splits the blocks written before headers were stored
+// on their own into a header and a body
+func migrateLegacyBlocks(tx StoreTx) error {
+	var blocks []*Block
+	err := tx.ForEach(blocksBucket, func(k, v []byte) error {
+		if string(k) != tipKey {
+			blocks = append(blocks, deserializeLegacyBlock(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	relinkLegacyCoinbase(blocks)
+
+	for _, block := range blocks {
+		err = putBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateGobBlocks rewrites the gob encoded headers and bodies in the
+// binary encoding. Transaction IDs are kept as they were stored.
+func migrateGobBlocks(tx StoreTx) error {
+	var blocks []*Block
+	err := tx.ForEach(headersBucket, func(k, v []byte) error {
+		bodyData := tx.Get(blocksBucket, k)
+		if bodyData == nil {
+			return errBlockNotFound
+		}
+
+		header := deserializeGobHeader(v)
+		blocks = append(blocks, &Block{*header, append([]byte{}, k...), deserializeGobBody(bodyData)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	relinkLegacyCoinbase(blocks)
+
+	for _, block := range blocks {
+		err = putBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relinkLegacyCoinbase gives an ID to a genesis coinbase stored without
+// one, which can not key the UTXO set or the indexes, and points the inputs
+// spending it, which refer to it by an empty Txid, at that ID. The Merkle
+// root its block was mined with is left as it is.
+func relinkLegacyCoinbase(blocks []*Block) {
+	var coinbaseID []byte
+	for _, block := range blocks {
+		coinbase := block.Transactions[0]
+		if len(block.PrevBlockHash) == 0 && len(coinbase.ID) == 0 {
+			coinbase.ID = coinbase.Hash()
+			coinbaseID = coinbase.ID
+		}
+	}
+	if coinbaseID == nil {
+		return
+	}
+
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.IsCoinbase() {
+				continue
+			}
+			for i := range tx.Vin {
+				if len(tx.Vin[i].Txid) == 0 {
+					tx.Vin[i].Txid = coinbaseID
+				}
+			}
+		}
+	}
+}
+
+func getStoreVersion(tx StoreTx) int {
+	data := tx.Get(metaBucket, []byte(storeVersionKey))
+	if data == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func setStoreVersion(tx StoreTx) error {
+	return tx.Put(metaBucket, []byte(storeVersionKey), IntToHex(storeVersion))
+}
+
+// getTip returns a copy of the tip hash, which callers keep after tx ends
+func getTip(tx StoreTx) []byte {
+	return append([]byte{}, tx.Get(blocksBucket, []byte(tipKey))...)
+}
+
+func setTip(tx StoreTx, hash []byte) error {
+	return tx.Put(blocksBucket, []byte(tipKey), hash)
+}
+
+// walkChain calls fn for every block from the tip back to genesis
+func walkChain(tx StoreTx, fn func(block *Block) error) error {
+	hash := getTip(tx)
+	for len(hash) > 0 {
+		block, err := getBlock(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		err = fn(block)
+		if err != nil {
+			return err
+		}
+
+		hash = block.PrevBlockHash
+	}
+	return nil
+}
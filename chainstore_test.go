@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRelinkLegacyCoinbase(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+
+	// the genesis coinbase was stored without an ID and spent by an input
+	// with an empty Txid
+	coinbase := NewCoinbaseTX(string(alice.GetAddress()), "", 0, params.Subsidy)
+	coinbase.ID = nil
+	spend := testSpend(coinbase, alice, bob, 8)
+	reward := NewCoinbaseTX(string(miner.GetAddress()), "", 1, params.Subsidy)
+
+	genesis := &Block{Transactions: []*Transaction{coinbase}}
+	b1 := &Block{Transactions: []*Transaction{reward, spend}}
+	b1.PrevBlockHash = []byte("genesis")
+
+	relinkLegacyCoinbase([]*Block{genesis, b1})
+	if !bytes.Equal(coinbase.ID, coinbase.Hash()) {
+		t.Errorf("coinbase ID is %x, want %x", coinbase.ID, coinbase.Hash())
+	}
+	if !bytes.Equal(spend.Vin[0].Txid, coinbase.ID) {
+		t.Errorf("input spends %x, want %x", spend.Vin[0].Txid, coinbase.ID)
+	}
+	if len(reward.Vin[0].Txid) != 0 {
+		t.Errorf("coinbase input spends %x", reward.Vin[0].Txid)
+	}
+}
+
+func TestGetTip(t *testing.T) {
+	bc := newTestChain(NewWallet())
+	want := append([]byte{}, bc.tip...)
+
+	// the hash returned belongs to the caller, who may keep or change it
+	var tip []byte
+	err := bc.store.View(func(tx StoreTx) error {
+		tip = getTip(tx)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tip[0] ^= 0xff
+
+	got, err := bc.store.GetTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("tip is %x, want %x", got, want)
+	}
+}
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // CLI responsible for processing command line arguments
 type CLI struct{}
 
 func (cli *CLI) createBlockchain(address string) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
 	bc := CreateBlockchain(address)
-	bc.db.Close()
+	bc.store.Close()
 	fmt.Println("Done!")
 }
 
@@ -29,19 +38,410 @@ func (cli *CLI) listAddresses() {
 }
 
 func (cli *CLI) getBalance(address string) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
 	bc := NewBlockchain(address)
-	defer bc.db.Close()
+	defer bc.store.Close()
 
-	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := bc.FindUTXO(pubKeyHash)
+	lockingScript, _ := AddressScript(address)
+	UTXOSet := UTXOSet{bc}
+	balance, immature := UTXOSet.GetBalance(lockingScript)
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	if immature > 0 {
+		fmt.Printf("Immature: %d\n", immature)
 	}
+}
 
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
+func (cli *CLI) mine(address string) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	bc := NewBlockchain(address)
+	defer bc.store.Close()
+
+	block := bc.MineBlock(address, nil)
+	fmt.Printf("Mined block %x at height %d\n", block.Hash, block.Height)
+}
+
+func (cli *CLI) reindexUTXO() {
+	bc := NewBlockchain("")
+	defer bc.store.Close()
+
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	count := UTXOSet.CountTransactions()
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+}
+
+func (cli *CLI) reindexTransactions() {
+	bc := NewBlockchain("")
+	defer bc.store.Close()
+
+	count := bc.ReindexTransactions()
+	fmt.Printf("Done! There are %d transactions in the transaction index.\n", count)
+}
+
+func (cli *CLI) history(address string) {
+	if !ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+	bc := NewBlockchain(address)
+	defer bc.store.Close()
+
+	lockingScript, _ := AddressScript(address)
+	key, _ := addressKey(lockingScript)
+	entries := bc.AddressHistory([]byte(key))
+
+	var order []string
+	heights := make(map[string]int)
+	received := make(map[string]int)
+	sent := make(map[string]int)
+	for _, entry := range entries {
+		txID := hex.EncodeToString(entry.Txid)
+		if _, ok := heights[txID]; !ok {
+			order = append(order, txID)
+			heights[txID] = entry.Height
+		}
+		if entry.Direction == AddrReceived {
+			received[txID] += entry.Value
+		} else {
+			sent[txID] += entry.Value
+		}
+	}
+
+	fmt.Printf("History of '%s':\n", address)
+	total := 0
+	for _, txID := range order {
+		net := received[txID] - sent[txID]
+		total += net
+		fmt.Printf("  %s height %d: received %d, sent %d, net %+d\n", txID, heights[txID], received[txID], sent[txID], net)
+	}
+	fmt.Printf("Balance: %d\n", total)
+}
+
+func (cli *CLI) getMerkleProof(txID string) {
+	bc := NewBlockchain("")
+	defer bc.store.Close()
+
+	ID, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockHash, proof, err := bc.GetMerkleProof(ID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Block: %x\n", blockHash)
+	fmt.Printf("Proof: %s\n", EncodeMerkleProof(proof))
+}
+
+func (cli *CLI) verifyMerkleProof(txID, blockHash, encodedProof string) {
+	bc := NewBlockchain("")
+	defer bc.store.Close()
+
+	ID, err := hex.DecodeString(txID)
+	if err != nil || len(ID) != sha256.Size {
+		log.Panic("ERROR: Transaction ID is not valid")
+	}
+	hash, err := hex.DecodeString(blockHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	proof, err := DecodeMerkleProof(encodedProof)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	valid, err := bc.VerifyMerkleProof(hash, ID, proof)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !valid {
+		fmt.Println("Proof is invalid.")
+		os.Exit(1)
+	}
+	fmt.Println("Proof is valid.")
+}
+
+// createMultisig prints the address of outputs that required of keys have
+// to sign. A key is the address of a local wallet or a hex public key.
+func (cli *CLI) createMultisig(required int, keys string) {
+	wallets, _ := NewWallets()
+
+	var pubKeys [][]byte
+	for _, key := range strings.Split(keys, ",") {
+		if wallet, ok := wallets.Wallets[key]; ok {
+			pubKeys = append(pubKeys, wallet.PublicKey)
+			continue
+		}
+		pubKey, err := hex.DecodeString(key)
+		if err != nil || len(pubKey) != 2*keyCoordinateSize {
+			log.Panicf("ERROR: %s is neither a local wallet address nor a public key", key)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	if required < 1 || required > len(pubKeys) || len(pubKeys) > maxMultisigKeys {
+		log.Panicf("ERROR: can not require %d of %d keys", required, len(pubKeys))
+	}
+
+	redeemScript := multisigScript(required, pubKeys)
+	fmt.Printf("Multisig address: %s\n", MultisigAddress(required, pubKeys))
+	// the redeem script has to fit in a single push of the ScriptSig
+	if len(redeemScript) <= maxScriptElementSize {
+		fmt.Printf("Script hash address: %s\n", ScriptHashAddress(redeemScript))
+		fmt.Printf("Redeem script: %x\n", redeemScript)
+	}
+}
+
+// createMultisigTx prints an unsigned transaction spending from the multisig
+// address from, to be passed to signmultisigtx. A script hash address needs
+// the redeem script printed by createmultisig.
+func (cli *CLI) createMultisigTx(from, to, scriptHex string, amount, fee int) {
+	fromScript, err := AddressScript(from)
+	if err != nil {
+		log.Panic(err)
+	}
+	var redeemScript []byte
+	if scriptHash, ok := extractScriptHash(fromScript); ok {
+		redeemScript, err = hex.DecodeString(scriptHex)
+		if err != nil || !bytes.Equal(HashPubKey(redeemScript), scriptHash) {
+			log.Panic("ERROR: Redeem script does not match the sender address")
+		}
+		fromScript = redeemScript
+	}
+	if _, _, ok := extractMultisig(fromScript); !ok {
+		log.Panic("ERROR: Sender address is not a multisig address")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+	bc := NewBlockchain(from)
+	defer bc.store.Close()
+
+	UTXOSet := UTXOSet{bc}
+	tx := NewMultisigTransaction(from, to, redeemScript, amount, fee, &UTXOSet)
+	fmt.Printf("Transaction: %x\n", tx.Serialize())
+}
+
+// signMultisigTx adds the signature of the wallet address to the multisig
+// inputs of a transaction and prints it along with the signatures each
+// input still misses
+func (cli *CLI) signMultisigTx(txHex, address string) {
+	tx := cli.decodeTransaction(txHex)
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panic("ERROR: Address is not in the wallet file")
+	}
+	bc := NewBlockchain(address)
+	defer bc.store.Close()
+
+	if bc.SignMultisigTransaction(tx, wallet.PrivateKey) == 0 {
+		fmt.Println("The wallet holds none of the keys of the spent outputs.")
+	}
+
+	prevTXs := bc.prevTransactions(tx)
+	for inID, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		redeemScript, signatures, ok := multisigSpend(vin, prevTx.Vout[vin.Vout].ScriptPubKey)
+		if !ok {
+			continue
+		}
+		m, _, _ := extractMultisig(redeemScript)
+		fmt.Printf("Input %d: %d of %d signatures\n", inID, len(signatures), m)
+	}
+	fmt.Printf("Transaction: %x\n", tx.Serialize())
+}
+
+// sendTx mines a block holding a transaction built elsewhere, paying the
+// reward and fee to miner
+func (cli *CLI) sendTx(txHex, miner string) {
+	if !ValidateAddress(miner) {
+		log.Panic("ERROR: Miner address is not valid")
+	}
+	tx := cli.decodeTransaction(txHex)
+	bc := NewBlockchain(miner)
+	defer bc.store.Close()
+
+	err := bc.CheckTransaction(tx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fee, err := bc.TransactionFee(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.MineBlock(miner, []*Transaction{tx})
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Printf("Fee: %d (%d bytes)\n", fee, tx.Size())
+	fmt.Println("Success!")
+}
+
+// createHTLC locks amount from the wallet address from in an HTLC output
+// that to can claim with the preimage of hash until timeout. Without hash a
+// random secret is made up and printed.
+func (cli *CLI) createHTLC(from, to string, amount, fee int, timeout int64, hashHex string) {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+	hash := cli.htlcHash(hashHex)
+
+	bc := NewBlockchain(from)
+	defer bc.store.Close()
+
+	UTXOSet := UTXOSet{bc}
+	tx := NewHTLCTransaction(from, to, hash, timeout, amount, fee, &UTXOSet)
+	bc.MineBlock(from, []*Transaction{tx})
+	fmt.Printf("Hash: %x\n", hash)
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Println("Success!")
+}
+
+// createHTLCAddress prints the script hash address of an HTLC with the
+// terms createHTLC takes. Anyone can fund it with send; claimhtlc and
+// refundhtlc need the redeem script it prints.
+func (cli *CLI) createHTLCAddress(sender, recipient string, timeout int64, hashHex string) {
+	senderHash := cli.walletPubKeyHash(sender)
+	recipientHash := cli.walletPubKeyHash(recipient)
+	hash := cli.htlcHash(hashHex)
+
+	redeemScript := htlcScript(hash, recipientHash, senderHash, timeout)
+	fmt.Printf("Hash: %x\n", hash)
+	fmt.Printf("Script hash address: %s\n", ScriptHashAddress(redeemScript))
+	fmt.Printf("Redeem script: %x\n", redeemScript)
+}
+
+// htlcHash decodes the hash of an HTLC, or makes up a random secret and
+// prints it when hashHex is empty
+func (cli *CLI) htlcHash(hashHex string) []byte {
+	if hashHex == "" {
+		secret := make([]byte, sha256.Size)
+		_, err := rand.Read(secret)
+		if err != nil {
+			log.Panic(err)
+		}
+		hash := sha256.Sum256(secret)
+		fmt.Printf("Secret: %x\n", secret)
+		return hash[:]
+	}
+
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil || len(hash) != sha256.Size {
+		log.Panic("ERROR: Hash is not a hex SHA-256 hash")
+	}
+	return hash
+}
+
+func (cli *CLI) walletPubKeyHash(address string) []byte {
+	script, err := AddressScript(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	pubKeyHash, ok := extractPubKeyHash(script)
+	if !ok {
+		log.Panicf("ERROR: %s is not a wallet address", address)
+	}
+	return pubKeyHash
+}
+
+// spendHTLC sends the HTLC output of transaction txID to the wallet address.
+// The recipient claims it with preimageHex, the sender refunds it with an
+// empty one. An output paying to a script hash is found by its redeem
+// script, scriptHex.
+func (cli *CLI) spendHTLC(txID, preimageHex, scriptHex, address string, fee int) {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		log.Panic("ERROR: Address is not in the wallet file")
+	}
+	ID, err := hex.DecodeString(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	var preimage, redeemScript []byte
+	if preimageHex != "" {
+		preimage, err = hex.DecodeString(preimageHex)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	if scriptHex != "" {
+		redeemScript, err = hex.DecodeString(scriptHex)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	bc := NewBlockchain(address)
+	defer bc.store.Close()
+
+	prevTx, _, err := bc.FindTransaction(ID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	vout := -1
+	var contract htlcContract
+	for i, out := range prevTx.Vout {
+		script := out.ScriptPubKey
+		if redeemScript != nil && bytes.Equal(script, scriptHashScript(HashPubKey(redeemScript))) {
+			script = redeemScript
+		}
+		if contract, ok = extractHTLC(script); ok {
+			vout = i
+			break
+		}
+	}
+	if vout < 0 {
+		log.Panic("ERROR: Transaction has no HTLC output")
+	}
+	owner := contract.Sender
+	if preimage != nil {
+		owner = contract.Recipient
+	}
+	if !bytes.Equal(HashPubKey(wallet.PublicKey), owner) {
+		log.Panic("ERROR: Address can not spend the HTLC output this way")
+	}
+
+	tx := NewHTLCSpendTransaction(wallet, &prevTx, vout, redeemScript, preimage, address, fee)
+	err = bc.CheckTransaction(tx)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	bc.MineBlock(address, []*Transaction{tx})
+	fmt.Printf("Transaction: %x\n", tx.ID)
+	fmt.Println("Success!")
+}
+
+func (cli *CLI) decodeTransaction(txHex string) *Transaction {
+	data, err := hex.DecodeString(txHex)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	return tx
 }
 
 func (cli *CLI) createWallet() {
@@ -51,37 +451,65 @@ func (cli *CLI) createWallet() {
 	fmt.Printf("Your new address: %s\n", address)
 }
 func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: [-network main|test|regtest] COMMAND")
+	fmt.Println("Commands:")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("  history -address ADDRESS - Print the transactions that paid to or spent from ADDRESS")
 	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  printchain - Print all the blocks of the blockchain")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("  getblock -height HEIGHT | -hash HASH - Print the block at HEIGHT or with HASH")
+	fmt.Println("  getmerkleproof -txid TXID - Print the block holding TXID and the proof of its inclusion")
+	fmt.Println("  verifymerkleproof -txid TXID -block HASH -proof PROOF - Check that PROOF links TXID to block HASH")
+	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
+	fmt.Println("  reindextx - Rebuilds the transaction index")
+	fmt.Println("  mine -address ADDRESS - Mine a block without transactions and send its reward to ADDRESS")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT [-fee FEE | -feerate RATE] [-locktime LOCKTIME] - Send AMOUNT of coins from FROM address to TO, paying FEE or RATE per byte. A transaction locked past the next block is printed for sendtx instead")
+	fmt.Println("  createmultisig -required M -keys KEY,KEY,... - Print the multisig and script hash addresses of outputs M of the keys have to sign, each KEY being a wallet address or a hex public key")
+	fmt.Println("  createmultisigtx -from FROM -to TO -amount AMOUNT [-fee FEE] [-script SCRIPT] - Print an unsigned transaction sending AMOUNT from multisig address FROM to TO, SCRIPT being the redeem script of a script hash address")
+	fmt.Println("  signmultisigtx -tx TX -address ADDRESS - Add the signature of wallet ADDRESS to the multisig inputs of TX")
+	fmt.Println("  sendtx -tx TX -miner ADDRESS - Mine a block with the signed transaction TX, sending its reward to ADDRESS")
+	fmt.Println("  createhtlc -from FROM -to TO -amount AMOUNT -timeout HEIGHT [-hash HASH] [-fee FEE] - Lock AMOUNT for TO to claim with the preimage of HASH, or for FROM to refund after block HEIGHT")
+	fmt.Println("  createhtlcaddress -sender SENDER -recipient RECIPIENT -timeout HEIGHT [-hash HASH] - Print the script hash address of an HTLC that anyone can fund")
+	fmt.Println("  claimhtlc -txid TXID -preimage PREIMAGE -address ADDRESS [-fee FEE] [-script SCRIPT] - Claim the HTLC output of TXID for its recipient ADDRESS, SCRIPT being the redeem script of a script hash address")
+	fmt.Println("  refundhtlc -txid TXID -address ADDRESS [-fee FEE] [-script SCRIPT] - Refund the HTLC output of TXID to its sender ADDRESS after the timeout")
 }
 
-func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CLI) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		os.Exit(1)
 	}
 }
 
+// parseNetwork reads the flags given before the command, selects the
+// network they name and returns the remaining arguments
+func (cli *CLI) parseNetwork() []string {
+	networkFlags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	network := networkFlags.String("network", MainNetParams.Name, "Network to use: main, test or regtest")
+	err := networkFlags.Parse(os.Args[1:])
+	if err != nil {
+		log.Panic(err)
+	}
+
+	params, err = NetworkParams(*network)
+	if err != nil {
+		fmt.Println(err)
+		cli.printUsage()
+		os.Exit(1)
+	}
+	return networkFlags.Args()
+}
+
 func (cli *CLI) printChain() {
 	bc := NewBlockchain("")
-	defer bc.db.Close()
+	defer bc.store.Close()
 
 	bci := bc.Iterator()
 
 	for {
 		block := bci.Next()
 
-		fmt.Printf("============ Block %x ============\n", block.Hash)
-		fmt.Printf("Prev.block: %x\n", block.PrevBlockHash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
-		fmt.Printf("\n\n")
+		cli.printBlock(bc, block)
 
 		if len(block.PrevBlockHash) == 0 {
 			break
@@ -89,60 +517,262 @@ func (cli *CLI) printChain() {
 	}
 }
 
-func (cli *CLI) send(from, to string, amount int) {
+func (cli *CLI) printBlock(bc *Blockchain, block *Block) {
+	fmt.Printf("============ Block %x ============\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Version: %d\n", block.Version)
+	fmt.Printf("Prev.block: %x\n", block.PrevBlockHash)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Printf("Timestamp: %d\n", block.Timestamp)
+	fmt.Printf("Bits: %d\n", block.Bits)
+	fmt.Printf("Nonce: %d\n", block.Nonce)
+	fmt.Printf("Size: %d bytes\n", block.Size())
+	pow := NewProofOfWork(&block.BlockHeader)
+	fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+		if !tx.IsCoinbase() {
+			fee, err := bc.TransactionFee(tx)
+			if err != nil {
+				log.Panic(err)
+			}
+			fmt.Printf("     Fee: %d\n", fee)
+		}
+	}
+	fmt.Printf("\n\n")
+}
+
+func (cli *CLI) getBlock(height int, hash string) {
+	bc := NewBlockchain("")
+	defer bc.store.Close()
+
+	var block *Block
+	var err error
+	if hash != "" {
+		blockHash, decodeErr := hex.DecodeString(hash)
+		if decodeErr != nil {
+			log.Panic(decodeErr)
+		}
+		block, err = bc.GetBlock(blockHash)
+	} else {
+		block, err = bc.GetBlockByHeight(height)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	cli.printBlock(bc, block)
+}
+
+func (cli *CLI) send(from, to string, amount, fee, feeRate int, lockTime int64) {
+	if !ValidateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+	if !ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
 	bc := NewBlockchain(from)
-	defer bc.db.Close()
+	defer bc.store.Close()
 
-	tx := NewUTXOTransaction(from, to, amount, bc)
-	bc.MineBlock([]*Transaction{tx})
+	UTXOSet := UTXOSet{bc}
+	tx := NewUTXOTransaction(from, to, amount, fee, feeRate, lockTime, &UTXOSet)
+	// a transaction that can not be mined yet is left to sendtx
+	err := bc.CheckTransaction(tx)
+	if errors.Is(err, ErrNonFinalTx) {
+		fmt.Printf("Transaction is locked until %d, send it with sendtx after that\n", tx.LockTime)
+		fmt.Printf("Transaction: %x\n", tx.Serialize())
+		return
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	fee, err = bc.TransactionFee(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc.MineBlock(from, []*Transaction{tx})
+	fmt.Printf("Fee: %d (%d bytes)\n", fee, tx.Size())
 	fmt.Println("Success!")
 }
 
 // Run parses command line arguments and processes commands
 func (cli *CLI) Run() {
-	cli.validateArgs()
+	args := cli.parseNetwork()
+	cli.validateArgs(args)
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	mineCmd := flag.NewFlagSet("mine", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	getMerkleProofCmd := flag.NewFlagSet("getmerkleproof", flag.ExitOnError)
+	verifyMerkleProofCmd := flag.NewFlagSet("verifymerkleproof", flag.ExitOnError)
+	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createMultisigTxCmd := flag.NewFlagSet("createmultisigtx", flag.ExitOnError)
+	signMultisigTxCmd := flag.NewFlagSet("signmultisigtx", flag.ExitOnError)
+	sendTxCmd := flag.NewFlagSet("sendtx", flag.ExitOnError)
+	createHTLCCmd := flag.NewFlagSet("createhtlc", flag.ExitOnError)
+	claimHTLCCmd := flag.NewFlagSet("claimhtlc", flag.ExitOnError)
+	refundHTLCCmd := flag.NewFlagSet("refundhtlc", flag.ExitOnError)
+	createHTLCAddressCmd := flag.NewFlagSet("createhtlcaddress", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee to pay")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee to pay per byte of the transaction")
+	sendLockTime := sendCmd.Int64("locktime", 0, "Block height or Unix time before which the transaction can not be mined")
+	mineAddress := mineCmd.String("address", "", "The address to send the block reward to")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+	historyAddress := historyCmd.String("address", "", "The address to print the history of")
+	getMerkleProofTxID := getMerkleProofCmd.String("txid", "", "ID of the transaction")
+	verifyMerkleProofTxID := verifyMerkleProofCmd.String("txid", "", "ID of the transaction")
+	verifyMerkleProofBlock := verifyMerkleProofCmd.String("block", "", "Hash of the block")
+	verifyMerkleProofProof := verifyMerkleProofCmd.String("proof", "", "Proof printed by getmerkleproof")
+	createMultisigRequired := createMultisigCmd.Int("required", 0, "Number of signatures required")
+	createMultisigKeys := createMultisigCmd.String("keys", "", "Comma separated wallet addresses or hex public keys")
+	createMultisigTxFrom := createMultisigTxCmd.String("from", "", "Source multisig address")
+	createMultisigTxTo := createMultisigTxCmd.String("to", "", "Destination address")
+	createMultisigTxAmount := createMultisigTxCmd.Int("amount", 0, "Amount to send")
+	createMultisigTxFee := createMultisigTxCmd.Int("fee", 0, "Fee to pay")
+	createMultisigTxScript := createMultisigTxCmd.String("script", "", "Hex redeem script of a script hash source address")
+	signMultisigTxTx := signMultisigTxCmd.String("tx", "", "Hex encoded transaction")
+	signMultisigTxAddress := signMultisigTxCmd.String("address", "", "Wallet address to sign with")
+	sendTxTx := sendTxCmd.String("tx", "", "Hex encoded signed transaction")
+	sendTxMiner := sendTxCmd.String("miner", "", "The address to send the block reward to")
+	createHTLCFrom := createHTLCCmd.String("from", "", "Source wallet address, which can refund the output")
+	createHTLCTo := createHTLCCmd.String("to", "", "Wallet address which can claim the output")
+	createHTLCAmount := createHTLCCmd.Int("amount", 0, "Amount to lock")
+	createHTLCFee := createHTLCCmd.Int("fee", 0, "Fee to pay")
+	createHTLCTimeout := createHTLCCmd.Int64("timeout", 0, "Block height after which the sender can refund the output")
+	createHTLCHash := createHTLCCmd.String("hash", "", "Hex SHA-256 hash of the secret, made up when empty")
+	claimHTLCTxID := claimHTLCCmd.String("txid", "", "ID of the transaction with the HTLC output")
+	claimHTLCPreimage := claimHTLCCmd.String("preimage", "", "Hex secret hashing to the hash of the contract")
+	claimHTLCAddress := claimHTLCCmd.String("address", "", "Recipient wallet address")
+	claimHTLCFee := claimHTLCCmd.Int("fee", 0, "Fee to pay")
+	claimHTLCScript := claimHTLCCmd.String("script", "", "Hex redeem script of a script hash HTLC")
+	refundHTLCTxID := refundHTLCCmd.String("txid", "", "ID of the transaction with the HTLC output")
+	refundHTLCAddress := refundHTLCCmd.String("address", "", "Sender wallet address")
+	refundHTLCFee := refundHTLCCmd.Int("fee", 0, "Fee to pay")
+	refundHTLCScript := refundHTLCCmd.String("script", "", "Hex redeem script of a script hash HTLC")
+	createHTLCAddressSender := createHTLCAddressCmd.String("sender", "", "Wallet address which can refund the outputs")
+	createHTLCAddressRecipient := createHTLCAddressCmd.String("recipient", "", "Wallet address which can claim the outputs")
+	createHTLCAddressTimeout := createHTLCAddressCmd.Int64("timeout", 0, "Block height after which the sender can refund the outputs")
+	createHTLCAddressHash := createHTLCAddressCmd.String("hash", "", "Hex SHA-256 hash of the secret, made up when empty")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getmerkleproof":
+		err := getMerkleProofCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymerkleproof":
+		err := verifyMerkleProofCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "mine":
+		err := mineCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexutxo":
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createmultisigtx":
+		err := createMultisigTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmultisigtx":
+		err := signMultisigTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "sendtx":
+		err := sendTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createhtlc":
+		err := createHTLCCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "claimhtlc":
+		err := claimHTLCCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "refundhtlc":
+		err := refundHTLCCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "createhtlcaddress":
+		err := createHTLCAddressCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -179,12 +809,136 @@ func (cli *CLI) Run() {
 		cli.printChain()
 	}
 
+	if reindexUTXOCmd.Parsed() {
+		cli.reindexUTXO()
+	}
+
+	if reindexTxCmd.Parsed() {
+		cli.reindexTransactions()
+	}
+
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(*getBlockHeight, *getBlockHash)
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" {
+			historyCmd.Usage()
+			os.Exit(1)
+		}
+		cli.history(*historyAddress)
+	}
+
+	if getMerkleProofCmd.Parsed() {
+		if *getMerkleProofTxID == "" {
+			getMerkleProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getMerkleProof(*getMerkleProofTxID)
+	}
+
+	if verifyMerkleProofCmd.Parsed() {
+		if *verifyMerkleProofTxID == "" || *verifyMerkleProofBlock == "" {
+			verifyMerkleProofCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyMerkleProof(*verifyMerkleProofTxID, *verifyMerkleProofBlock, *verifyMerkleProofProof)
+	}
+
+	if mineCmd.Parsed() {
+		if *mineAddress == "" {
+			mineCmd.Usage()
+			os.Exit(1)
+		}
+		cli.mine(*mineAddress)
+	}
+
 	if sendCmd.Parsed() {
 		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
+		if *sendFee < 0 || *sendFeeRate < 0 || (*sendFee > 0 && *sendFeeRate > 0) || *sendLockTime < 0 {
+			sendCmd.Usage()
+			os.Exit(1)
+		}
+
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendFeeRate, *sendLockTime)
+	}
+
+	if createMultisigCmd.Parsed() {
+		if *createMultisigRequired <= 0 || *createMultisigKeys == "" {
+			createMultisigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisig(*createMultisigRequired, *createMultisigKeys)
+	}
+
+	if createMultisigTxCmd.Parsed() {
+		if *createMultisigTxFrom == "" || *createMultisigTxTo == "" || *createMultisigTxAmount <= 0 || *createMultisigTxFee < 0 {
+			createMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisigTx(*createMultisigTxFrom, *createMultisigTxTo, *createMultisigTxScript, *createMultisigTxAmount, *createMultisigTxFee)
+	}
+
+	if signMultisigTxCmd.Parsed() {
+		if *signMultisigTxTx == "" || *signMultisigTxAddress == "" {
+			signMultisigTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMultisigTx(*signMultisigTxTx, *signMultisigTxAddress)
+	}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+	if sendTxCmd.Parsed() {
+		if *sendTxTx == "" || *sendTxMiner == "" {
+			sendTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendTx(*sendTxTx, *sendTxMiner)
+	}
+
+	if createHTLCCmd.Parsed() {
+		if *createHTLCFrom == "" || *createHTLCTo == "" || *createHTLCAmount <= 0 || *createHTLCFee < 0 {
+			createHTLCCmd.Usage()
+			os.Exit(1)
+		}
+		if *createHTLCTimeout <= 0 || *createHTLCTimeout >= lockTimeThreshold {
+			createHTLCCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createHTLC(*createHTLCFrom, *createHTLCTo, *createHTLCAmount, *createHTLCFee, *createHTLCTimeout, *createHTLCHash)
+	}
+
+	if claimHTLCCmd.Parsed() {
+		if *claimHTLCTxID == "" || *claimHTLCPreimage == "" || *claimHTLCAddress == "" || *claimHTLCFee < 0 {
+			claimHTLCCmd.Usage()
+			os.Exit(1)
+		}
+		cli.spendHTLC(*claimHTLCTxID, *claimHTLCPreimage, *claimHTLCScript, *claimHTLCAddress, *claimHTLCFee)
+	}
+
+	if refundHTLCCmd.Parsed() {
+		if *refundHTLCTxID == "" || *refundHTLCAddress == "" || *refundHTLCFee < 0 {
+			refundHTLCCmd.Usage()
+			os.Exit(1)
+		}
+		cli.spendHTLC(*refundHTLCTxID, "", *refundHTLCScript, *refundHTLCAddress, *refundHTLCFee)
+	}
+
+	if createHTLCAddressCmd.Parsed() {
+		if *createHTLCAddressSender == "" || *createHTLCAddressRecipient == "" {
+			createHTLCAddressCmd.Usage()
+			os.Exit(1)
+		}
+		if *createHTLCAddressTimeout <= 0 || *createHTLCAddressTimeout >= lockTimeThreshold {
+			createHTLCAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createHTLCAddress(*createHTLCAddressSender, *createHTLCAddressRecipient, *createHTLCAddressTimeout, *createHTLCAddressHash)
 	}
 }
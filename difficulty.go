@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+)
+
+// nextBits returns the difficulty a block built on parent has to be mined
+// at. It only reads headers and runs inside the caller's store transaction.
+func nextBits(tx StoreTx, parent *BlockHeader) (int, error) {
+	if parent == nil {
+		return params.GenesisBits, nil
+	}
+
+	height := parent.Height + 1
+	if params.NoRetargeting || height%params.RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+	for i := 0; i < params.RetargetInterval-1; i++ {
+		var err error
+		first, err = getHeader(tx, first.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return retarget(parent.Bits, parent.Timestamp-first.Timestamp), nil
+}
+
+// retarget scales the difficulty by the ratio of the expected to the actual
+// time the last RetargetInterval blocks took. Every bit doubles the work, so
+// the ratio is applied as its rounded base 2 logarithm.
+func retarget(bits int, actualTimespan int64) int {
+	expectedTimespan := int64(params.TargetBlockTime * (params.RetargetInterval - 1))
+	if actualTimespan < 1 {
+		actualTimespan = 1
+	}
+
+	step := int(math.Round(math.Log2(float64(expectedTimespan) / float64(actualTimespan))))
+	if step > maxRetargetStep {
+		step = maxRetargetStep
+	}
+	if step < -maxRetargetStep {
+		step = -maxRetargetStep
+	}
+
+	newBits := bits + step
+	if newBits < minTargetBits {
+		newBits = minTargetBits
+	}
+	if newBits > maxTargetBits {
+		newBits = maxTargetBits
+	}
+	return newBits
+}
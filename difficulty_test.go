@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetarget(t *testing.T) {
+	expected := int64(params.TargetBlockTime * (params.RetargetInterval - 1))
+
+	cases := []struct {
+		name     string
+		bits     int
+		timespan int64
+		want     int
+	}{
+		{"on time", 20, expected, 20},
+		{"twice as fast", 20, expected / 2, 21},
+		{"four times as fast", 20, expected / 4, 22},
+		{"twice as slow", 20, expected * 2, 19},
+		{"step limit up", 20, 1, 20 + maxRetargetStep},
+		{"step limit down", 20, expected * 64, 20 - maxRetargetStep},
+		{"no time", 20, 0, 20 + maxRetargetStep},
+		{"negative time", 20, -expected, 20 + maxRetargetStep},
+		{"minimum", minTargetBits, expected * 4, minTargetBits},
+		{"maximum", maxTargetBits, 1, maxTargetBits},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := retarget(c.bits, c.timespan); got != c.want {
+				t.Errorf("got %d bits, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestNextBits(t *testing.T) {
+	defer func(p *ChainParams) { params = p }(params)
+	retargeting := *params
+	retargeting.NoRetargeting = false
+	params = &retargeting
+
+	miner := NewWallet()
+	bc := newTestChain(miner)
+	tip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tip.Height < params.RetargetInterval-1 {
+		tip = newTestBlock(tip, miner)
+		err = bc.AddBlock(tip)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := func() int {
+		var bits int
+		err := bc.store.View(func(tx StoreTx) error {
+			var err error
+			bits, err = nextBits(tx, &tip.BlockHeader)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bits
+	}
+
+	// the blocks were mined back to back, far faster than TargetBlockTime
+	want := params.GenesisBits + maxRetargetStep
+	if bits := next(); bits != want {
+		t.Fatalf("next block needs %d bits, want %d", bits, want)
+	}
+	retargeting.NoRetargeting = true
+	if bits := next(); bits != params.GenesisBits {
+		t.Errorf("next block without retargeting needs %d bits, want %d", bits, params.GenesisBits)
+	}
+	retargeting.NoRetargeting = false
+
+	coinbase := NewCoinbaseTX(string(miner.GetAddress()), "", tip.Height+1, blockSubsidy(tip.Height+1))
+	err = bc.AddBlock(NewBlock([]*Transaction{coinbase}, tip.Hash, tip.Height+1, params.GenesisBits))
+	if !errors.Is(err, ErrBadDifficulty) {
+		t.Errorf("block at the old difficulty: got %v, want %v", err, ErrBadDifficulty)
+	}
+	err = bc.AddBlock(NewBlock([]*Transaction{coinbase}, tip.Hash, tip.Height+1, want))
+	if err != nil {
+		t.Errorf("block at the new difficulty: %v", err)
+	}
+}
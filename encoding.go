@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The binary encoding transaction IDs, signatures and stored blocks are
+// computed over. It is meant to be reimplemented outside of Go, so every
+// field has a fixed layout:
+//
+//	uint32, uint64  fixed width, big endian
+//	varint          unsigned LEB128 as written by binary.PutUvarint
+//	bytes           varint length followed by the bytes
+//
+// Transaction (the ID is not part of it)
+//
+//	uint32  Version
+//	varint  input count
+//	        per input: bytes Txid, uint32 Vout (0xffffffff for -1),
+//	        bytes ScriptSig, uint32 Sequence
+//	varint  output count
+//	        per output: uint64 Value, bytes ScriptPubKey
+//	uint64  LockTime
+//
+// Transactions of versions 0 and 1, below scriptTxVersion (2), have bytes
+// Signature and bytes PubKey in place of ScriptSig, and bytes PubKeyHash in
+// place of ScriptPubKey.
+//
+// Block header
+//
+//	uint32 Version, bytes PrevBlockHash, bytes MerkleRoot,
+//	uint64 Timestamp, uint32 Bits, uint64 Nonce, uint32 Height
+//
+// Block: the header, a varint transaction count and the transactions.
+
+var errMalformedData = errors.New("Malformed data")
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = appendVarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decoder reads the fields of the encoding back in order. The first
+// malformed field sets err and every later read returns zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errMalformedData
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) varint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errMalformedData
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads a varint length of at most what is left of the data, which
+// keeps a corrupt length from allocating huge slices
+func (d *decoder) count() int {
+	n := d.varint()
+	if n > uint64(len(d.data)) {
+		d.err = errMalformedData
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	b := d.next(n)
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// finish returns the first decoding error, or an error if data is left
+// over after the last field
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errMalformedData
+	}
+	return d.err
+}
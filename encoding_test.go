@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionEncoding(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	coinbase := NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy)
+	payment := testSpend(coinbase, alice, bob, 8)
+	payment.LockTime = 1000
+	payment.Vin[0].Sequence = 3
+	payment.ID = payment.Hash()
+
+	for _, tx := range []*Transaction{coinbase, payment} {
+		got, err := DeserializeTransaction(tx.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.ID, tx.ID) || got.String() != tx.String() {
+			t.Errorf("decoded %v, want %v", got, tx)
+		}
+	}
+
+	// the coinbase input spends output -1, written as 0xffffffff
+	data := coinbase.Serialize()
+	if !bytes.Contains(data, []byte{0, 0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("coinbase encoding %x does not hold Vout -1", data)
+	}
+}
+
+func TestDecodeMalformedTransaction(t *testing.T) {
+	alice := NewWallet()
+	data := NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy).Serialize()
+
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated", data[:len(data)-1]},
+		{"trailing data", append(append([]byte{}, data...), 0)},
+		{"huge input count", append(appendUint32(nil, txVersion), 0xff, 0xff, 0xff, 0xff, 0x0f)},
+		{"bad varint", append(appendUint32(nil, txVersion), 0xff)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := DeserializeTransaction(c.data)
+			if !errors.Is(err, errMalformedData) {
+				t.Errorf("got %v, want %v", err, errMalformedData)
+			}
+		})
+	}
+}
+
+func TestBlockBodyEncoding(t *testing.T) {
+	alice := NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stored IDs are read back as they were written, not recomputed
+	txs := append([]*Transaction{}, genesis.Transactions...)
+	legacy := *txs[0]
+	legacy.Version = 0
+	legacy.ID = []byte("stored ID")
+	txs = append(txs, &legacy)
+
+	block := &Block{genesis.BlockHeader, genesis.Hash, txs}
+	got := &Block{Transactions: deserializeBlockBody(block.serializeBody())}
+	if !bytes.Equal(got.serializeBody(), block.serializeBody()) || !bytes.Equal(got.Transactions[1].ID, legacy.ID) {
+		t.Errorf("decoded %v, want %v", got.Transactions, txs)
+	}
+	if got := DeserializeBlockHeader(block.BlockHeader.Serialize()); !reflect.DeepEqual(*got, block.BlockHeader) {
+		t.Errorf("decoded header %v, want %v", *got, block.BlockHeader)
+	}
+}
+
+func testGobEncode(t *testing.T, v interface{}) []byte {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testGobBody encodes the transactions of block the way bodies were stored
+// before the binary encoding
+func testGobBody(t *testing.T, block *Block) []byte {
+	var body blockBody
+	for _, tx := range block.Transactions {
+		gobTx := &gobTransaction{ID: tx.ID, LockTime: tx.LockTime}
+		for _, vin := range tx.Vin {
+			signature, pubKey, ok := legacyInput(vin)
+			if !ok {
+				t.Fatalf("input of %x has no legacy form", tx.ID)
+			}
+			gobTx.Vin = append(gobTx.Vin, gobTXInput{vin.Txid, vin.Vout, signature, pubKey, vin.Sequence})
+		}
+		for _, vout := range tx.Vout {
+			pubKeyHash, _ := extractPubKeyHash(vout.ScriptPubKey)
+			gobTx.Vout = append(gobTx.Vout, gobTXOutput{vout.Value, pubKeyHash})
+		}
+		body.Transactions = append(body.Transactions, gobTx)
+	}
+	return testGobEncode(t, body)
+}
+
+func TestMigrateGobBlocks(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := newTestBlock(genesis, miner, testPayment(bc, alice, bob, 8))
+	err = bc.AddBlock(b1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite the store the way it was kept before the binary encoding
+	err = bc.store.Batch(func(tx StoreTx) error {
+		for _, block := range []*Block{genesis, b1} {
+			err := tx.Put(headersBucket, block.Hash, testGobEncode(t, block.BlockHeader))
+			if err != nil {
+				return err
+			}
+			err = tx.Put(blocksBucket, block.Hash, testGobBody(t, block))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Delete(metaBucket, []byte(storeVersionKey))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc = LoadBlockchain(bc.store)
+	err = bc.store.View(func(tx StoreTx) error {
+		if version := getStoreVersion(tx); version != storeVersion {
+			t.Errorf("store version is %d, want %d", version, storeVersion)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []*Block{genesis, b1} {
+		got, err := bc.GetBlock(block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// gob encoded transactions are version 0 and keep their IDs
+		want := &Block{block.BlockHeader, block.Hash, nil}
+		for _, tx := range block.Transactions {
+			legacy := *tx
+			legacy.Version = 0
+			want.Transactions = append(want.Transactions, &legacy)
+		}
+		if !bytes.Equal(got.BlockHeader.Hash(), want.Hash) || !bytes.Equal(got.serializeBody(), want.serializeBody()) {
+			t.Errorf("block %x is %v after migration, want %v", want.Hash, got, want)
+		}
+	}
+	if got := testBalance(bc, bob); got != 8 {
+		t.Errorf("bob has %d, want 8", got)
+	}
+}
+
+func TestLegacyTransactionEncoding(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	coinbase := NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy)
+	legacy := *testSpend(coinbase, alice, bob, 8)
+	legacy.Version = scriptTxVersion - 1
+	legacy.ID = legacy.Hash()
+
+	// version 1 encodes the signature, public key and public key hash
+	// instead of the scripts
+	got, err := DeserializeTransaction(legacy.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.ID, legacy.ID) || got.String() != legacy.String() {
+		t.Errorf("decoded %v, want %v", got, legacy)
+	}
+	if !legacy.hasLegacyForm() {
+		t.Error("pay-to-pubkey-hash transaction has no legacy form")
+	}
+
+	// a script other than pay-to-pubkey-hash would be lost, so two such
+	// transactions could share an ID
+	other := legacy
+	other.Vout = []TXOutput{{8, []byte{opTrue}}}
+	other.ID = other.Hash()
+	if other.hasLegacyForm() {
+		t.Error("OP_TRUE output has a legacy form")
+	}
+	other.Version = scriptTxVersion
+	if !other.hasLegacyForm() {
+		t.Errorf("version %d transaction does not fit its encoding", scriptTxVersion)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+const heightIndexBucket = "heights"
+
+// indexHeight maps the height of block to its hash. It runs inside the
+// caller's store transaction.
+func indexHeight(tx StoreTx, block *Block) error {
+	return tx.Put(heightIndexBucket, IntToHex(int64(block.Height)), block.Hash)
+}
+
+func unindexHeight(tx StoreTx, block *Block) error {
+	return tx.Delete(heightIndexBucket, IntToHex(int64(block.Height)))
+}
+
+// ReindexHeights drops the height index and rebuilds it from the whole chain
+func (bc *Blockchain) ReindexHeights() {
+	err := bc.store.Batch(func(tx StoreTx) error {
+		err := tx.DropBucket(heightIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		return walkChain(tx, func(block *Block) error {
+			return indexHeight(tx, block)
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func (bc *Blockchain) GetBlock(hash []byte) (*Block, error) {
+	return bc.store.GetBlock(hash)
+}
+
+func (bc *Blockchain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.store.View(func(tx StoreTx) error {
+		hash = tx.Get(heightIndexBucket, IntToHex(int64(height)))
+		if hash == nil {
+			return errors.New("No block at this height")
+		}
+		return nil
+	})
+
+	return hash, err
+}
+
+func (bc *Blockchain) GetBlockByHeight(height int) (*Block, error) {
+	hash, err := bc.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.GetBlock(hash)
+}
@@ -12,12 +12,30 @@ func main() {
 	cli.Run()
 }
 
-const targetBits = 22
+// The rules below are shared by all networks, see ChainParams for the
+// others
+const maxRetargetStep = 2
+const minTargetBits = 1
+const maxTargetBits = 255
+const medianTimeSpan = 11
+const maxFutureBlockTime = 2 * 60 * 60
+
+// maxBlockSize bounds the serialized size of a block and is a consensus
+// rule. maxTxSize is only the policy for transactions this node mines or
+// relays.
+const maxBlockSize = 1000000
+const maxTxSize = 100000
+
+// maxMoney bounds every output value and every sum of values, so that they
+// can not overflow. No network issues more coins than that.
+const maxMoney = 21000000
+
+// lock times below lockTimeThreshold are block heights, the others Unix
+// timestamps
+const lockTimeThreshold = 500000000
 const maxNonce = math.MaxInt64
 const dbFile = "blockchain.db"
 const blocksBucket = "blocks"
-const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
-const version = byte(0x00)
 const walletFile = "wallet.dat"
 const addressChecksumLen = 4
 
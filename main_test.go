@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// regtest mines at a low difficulty and lets rewards be spent in the
+	// next block
+	params = &RegTestParams
+
+	// the clock moves one second per reading, so blocks mined back to back
+	// get distinct timestamps and runs are repeatable
+	clock := time.Unix(1700000000, 0)
+	timeNow = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	os.Exit(m.Run())
+}
+
+// newTestChain creates a chain in a MemoryStore whose genesis block pays
+// owner
+func newTestChain(owner *Wallet) *Blockchain {
+	return CreateBlockchainInStore(NewMemoryStore(), string(owner.GetAddress()))
+}
+
+// newTestBlock mines a block on parent holding a coinbase that pays miner
+// and txs
+func newTestBlock(parent *Block, miner *Wallet, txs ...*Transaction) *Block {
+	height := parent.Height + 1
+	coinbase := NewCoinbaseTX(string(miner.GetAddress()), "", height, blockSubsidy(height))
+	return NewBlock(append([]*Transaction{coinbase}, txs...), parent.Hash, height, params.GenesisBits)
+}
+
+// testPayment creates a transaction paying amount from the wallet from to
+// the wallet to out of the current UTXO set
+func testPayment(bc *Blockchain, from, to *Wallet, amount int) *Transaction {
+	return testTransfer(bc, from, to, amount, 0, 0)
+}
+
+// testTransfer is testPayment with a fee and a lock time
+func testTransfer(bc *Blockchain, from, to *Wallet, amount, fee int, lockTime int64) *Transaction {
+	payment := NewTXOutput(amount, string(to.GetAddress()))
+	return newTransferTransaction(from, string(from.GetAddress()), *payment, fee, lockTime, &UTXOSet{bc})
+}
+
+// testSpend creates a transaction paying value out of the first output of
+// prev, which owner holds, to the wallet to
+func testSpend(prev *Transaction, owner, to *Wallet, value int) *Transaction {
+	return testSplit(prev, owner, to, value)
+}
+
+// testSplit is testSpend with an output to the wallet to for each of values
+func testSplit(prev *Transaction, owner, to *Wallet, values ...int) *Transaction {
+	input := TXInput{prev.ID, 0, nil, 0}
+	tx := &Transaction{nil, txVersion, []TXInput{input}, nil, 0}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(value, string(to.GetAddress())))
+	}
+	tx.Sign(owner.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func testBalance(bc *Blockchain, w *Wallet) int {
+	balance, _ := UTXOSet{bc}.GetBalance(payToPubKeyHashScript(HashPubKey(w.PublicKey)))
+	return balance
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps the chain in memory only. It is meant for tests and
+// simulations that need a throwaway chain.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+type memWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// memStoreTx reads through to the store and buffers its writes until the
+// batch commits
+type memStoreTx struct {
+	store    *MemoryStore
+	writable bool
+	writes   map[string]map[string]memWrite
+	created  map[string]bool
+	dropped  map[string]bool
+}
+
+var errReadOnlyTx = errors.New("Transaction is read-only")
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *MemoryStore) GetHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+	err := s.View(func(tx StoreTx) error {
+		var err error
+		header, err = getHeader(tx, hash)
+		return err
+	})
+	return header, err
+}
+
+func (s *MemoryStore) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+	err := s.View(func(tx StoreTx) error {
+		var err error
+		block, err = getBlock(tx, hash)
+		return err
+	})
+	return block, err
+}
+
+func (s *MemoryStore) GetTip() ([]byte, error) {
+	var tip []byte
+	err := s.View(func(tx StoreTx) error {
+		tip = getTip(tx)
+		return nil
+	})
+	return tip, err
+}
+
+func (s *MemoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(s.newTx(false))
+}
+
+func (s *MemoryStore) Batch(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.newTx(true)
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	tx.commit()
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) newTx(writable bool) *memStoreTx {
+	return &memStoreTx{
+		store:    s,
+		writable: writable,
+		writes:   make(map[string]map[string]memWrite),
+		created:  make(map[string]bool),
+		dropped:  make(map[string]bool),
+	}
+}
+
+func (t *memStoreTx) commit() {
+	buckets := t.store.buckets
+
+	for bucket := range t.dropped {
+		delete(buckets, bucket)
+	}
+	for bucket := range t.created {
+		if buckets[bucket] == nil {
+			buckets[bucket] = make(map[string][]byte)
+		}
+	}
+	for bucket, writes := range t.writes {
+		for key, w := range writes {
+			if w.deleted {
+				delete(buckets[bucket], key)
+			} else {
+				buckets[bucket][key] = w.value
+			}
+		}
+	}
+}
+
+func (t *memStoreTx) Get(bucket string, key []byte) []byte {
+	if w, ok := t.writes[bucket][string(key)]; ok {
+		if w.deleted {
+			return nil
+		}
+		return w.value
+	}
+	if t.dropped[bucket] {
+		return nil
+	}
+	return t.store.buckets[bucket][string(key)]
+}
+
+func (t *memStoreTx) Put(bucket string, key, value []byte) error {
+	if !t.writable {
+		return errReadOnlyTx
+	}
+	if t.writes[bucket] == nil {
+		t.writes[bucket] = make(map[string]memWrite)
+	}
+	t.writes[bucket][string(key)] = memWrite{append([]byte{}, value...), false}
+	t.created[bucket] = true
+	return nil
+}
+
+func (t *memStoreTx) Delete(bucket string, key []byte) error {
+	if !t.writable {
+		return errReadOnlyTx
+	}
+	if t.writes[bucket] == nil {
+		t.writes[bucket] = make(map[string]memWrite)
+	}
+	t.writes[bucket][string(key)] = memWrite{nil, true}
+	return nil
+}
+
+func (t *memStoreTx) ForEach(bucket string, fn func(k, v []byte) error) error {
+	keySet := make(map[string]bool)
+	if !t.dropped[bucket] {
+		for key := range t.store.buckets[bucket] {
+			keySet[key] = true
+		}
+	}
+	for key := range t.writes[bucket] {
+		keySet[key] = true
+	}
+
+	var keys []string
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := t.Get(bucket, []byte(key))
+		if value == nil {
+			continue
+		}
+		err := fn([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *memStoreTx) HasBucket(bucket string) bool {
+	if t.created[bucket] {
+		return true
+	}
+	if t.dropped[bucket] {
+		return false
+	}
+	_, ok := t.store.buckets[bucket]
+	return ok
+}
+
+func (t *memStoreTx) DropBucket(bucket string) error {
+	if !t.writable {
+		return errReadOnlyTx
+	}
+	delete(t.writes, bucket)
+	delete(t.created, bucket)
+	t.dropped[bucket] = true
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var errTestAbort = errors.New("Test aborted the batch")
+
+// testBucket reads every key of bucket
+func testBucket(t *testing.T, store ChainStore, bucket string) map[string]string {
+	contents := make(map[string]string)
+	err := store.View(func(tx StoreTx) error {
+		return tx.ForEach(bucket, func(k, v []byte) error {
+			contents[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+func TestMemoryStoreBatch(t *testing.T) {
+	cases := []struct {
+		name  string
+		batch func(tx StoreTx) error
+		err   error
+		want  map[string]string
+	}{
+		{"put", func(tx StoreTx) error {
+			return tx.Put("b", []byte("c"), []byte("3"))
+		}, nil, map[string]string{"a": "1", "b": "2", "c": "3"}},
+		{"overwrite and delete", func(tx StoreTx) error {
+			tx.Put("b", []byte("a"), []byte("4"))
+			return tx.Delete("b", []byte("b"))
+		}, nil, map[string]string{"a": "4"}},
+		{"failed batch", func(tx StoreTx) error {
+			tx.Put("b", []byte("c"), []byte("3"))
+			tx.Delete("b", []byte("a"))
+			return errTestAbort
+		}, errTestAbort, map[string]string{"a": "1", "b": "2"}},
+		{"drop", func(tx StoreTx) error {
+			return tx.DropBucket("b")
+		}, nil, map[string]string{}},
+		{"drop and refill", func(tx StoreTx) error {
+			tx.DropBucket("b")
+			return tx.Put("b", []byte("c"), []byte("3"))
+		}, nil, map[string]string{"c": "3"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			err := store.Batch(func(tx StoreTx) error {
+				tx.Put("b", []byte("a"), []byte("1"))
+				return tx.Put("b", []byte("b"), []byte("2"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Batch(c.batch)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("got %v, want %v", err, c.err)
+			}
+			if got := testBucket(t, store, "b"); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("bucket holds %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreTx(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.Batch(func(tx StoreTx) error {
+		tx.Put("b", []byte("b"), []byte("2"))
+		return tx.Put("b", []byte("d"), []byte("4"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a batch sees its own writes, in key order with the stored ones
+	err = store.Batch(func(tx StoreTx) error {
+		tx.Put("b", []byte("c"), []byte("3"))
+		tx.Put("b", []byte("a"), []byte("1"))
+		tx.Delete("b", []byte("d"))
+		if v := tx.Get("b", []byte("c")); string(v) != "3" {
+			t.Errorf("read %q back, want 3", v)
+		}
+		if v := tx.Get("b", []byte("d")); v != nil {
+			t.Errorf("read deleted key as %q", v)
+		}
+
+		var keys []string
+		tx.ForEach("b", func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("keys are %v, want %v", keys, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.View(func(tx StoreTx) error {
+		if tx.HasBucket("missing") || !tx.HasBucket("b") {
+			t.Error("HasBucket does not match the stored buckets")
+		}
+		if err := tx.Put("b", []byte("e"), []byte("5")); err != errReadOnlyTx {
+			t.Errorf("Put in a view returned %v", err)
+		}
+		if err := tx.Delete("b", []byte("a")); err != errReadOnlyTx {
+			t.Errorf("Delete in a view returned %v", err)
+		}
+		if err := tx.DropBucket("b"); err != errReadOnlyTx {
+			t.Errorf("DropBucket in a view returned %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// MerkleTree keeps every level of the tree, leaves first. A level with an
+// odd number of nodes pairs its last node with itself.
+type MerkleTree struct {
+	Levels [][][]byte
+}
+
+// MerkleProofStep is the sibling hash needed to climb one level and
+// whether that sibling sits on the left
+type MerkleProofStep struct {
+	Left bool
+	Hash []byte
+}
+
+const merkleProofStepLen = 1 + sha256.Size
+
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var level [][]byte
+	for _, datum := range data {
+		hash := sha256.Sum256(datum)
+		level = append(level, hash[:])
+	}
+	if len(level) == 0 {
+		hash := sha256.Sum256([]byte{})
+		level = append(level, hash[:])
+	}
+
+	tree := MerkleTree{[][][]byte{level}}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, hashMerkleNodes(level[i], right))
+		}
+		tree.Levels = append(tree.Levels, next)
+		level = next
+	}
+
+	return &tree
+}
+
+func hashMerkleNodes(left, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+	return hash[:]
+}
+
+func (t *MerkleTree) Root() []byte {
+	return t.Levels[len(t.Levels)-1][0]
+}
+
+// Proof returns the steps from the leaf at index up to the root
+func (t *MerkleTree) Proof(index int) []MerkleProofStep {
+	var proof []MerkleProofStep
+
+	for _, level := range t.Levels[:len(t.Levels)-1] {
+		if index%2 == 0 {
+			sibling := level[index]
+			if index+1 < len(level) {
+				sibling = level[index+1]
+			}
+			proof = append(proof, MerkleProofStep{false, sibling})
+		} else {
+			proof = append(proof, MerkleProofStep{true, level[index-1]})
+		}
+		index /= 2
+	}
+
+	return proof
+}
+
+// MerkleRootFromProof climbs from datum to the root it commits to
+func MerkleRootFromProof(datum []byte, proof []MerkleProofStep) []byte {
+	hash := sha256.Sum256(datum)
+	node := hash[:]
+
+	for _, step := range proof {
+		if step.Left {
+			node = hashMerkleNodes(step.Hash, node)
+		} else {
+			node = hashMerkleNodes(node, step.Hash)
+		}
+	}
+
+	return node
+}
+
+// EncodeMerkleProof packs a proof as one side byte and one hash per step
+func EncodeMerkleProof(proof []MerkleProofStep) string {
+	var buff bytes.Buffer
+
+	for _, step := range proof {
+		if step.Left {
+			buff.WriteByte(1)
+		} else {
+			buff.WriteByte(0)
+		}
+		buff.Write(step.Hash)
+	}
+
+	return hex.EncodeToString(buff.Bytes())
+}
+
+func DecodeMerkleProof(encoded string) ([]MerkleProofStep, error) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%merkleProofStepLen != 0 {
+		return nil, errors.New("Merkle proof has a bad length")
+	}
+
+	var proof []MerkleProofStep
+	for i := 0; i < len(data); i += merkleProofStepLen {
+		if data[i] > 1 {
+			return nil, errors.New("Merkle proof has a bad side byte")
+		}
+		proof = append(proof, MerkleProofStep{data[i] == 1, data[i+1 : i+merkleProofStepLen]})
+	}
+
+	return proof, nil
+}
+
+// GetMerkleProof returns the hash of the block holding the transaction and
+// the proof of its inclusion
+func (bc *Blockchain) GetMerkleProof(txID []byte) ([]byte, []MerkleProofStep, error) {
+	_, loc, err := bc.FindTransaction(txID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := bc.GetBlock(loc.BlockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block.Hash, block.MerkleTree().Proof(loc.Index), nil
+}
+
+// VerifyMerkleProof checks that proof links txID to the Merkle root the
+// block with blockHash was mined over. Only the block header is read.
+func (bc *Blockchain) VerifyMerkleProof(blockHash, txID []byte, proof []MerkleProofStep) (bool, error) {
+	// the two child hashes of an inner node would pass for a 64 byte ID
+	if len(txID) != sha256.Size {
+		return false, errors.New("Transaction ID has a bad length")
+	}
+
+	header, err := bc.store.GetHeader(blockHash)
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(header.Hash(), blockHash) || !NewProofOfWork(header).Validate() {
+		return false, nil
+	}
+	return bytes.Equal(MerkleRootFromProof(txID, proof), header.MerkleRoot), nil
+}
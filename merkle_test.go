@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleTree(t *testing.T) {
+	for n := 1; n <= 7; n++ {
+		t.Run(fmt.Sprintf("%d leaves", n), func(t *testing.T) {
+			var data [][]byte
+			for i := 0; i < n; i++ {
+				data = append(data, []byte{byte(i)})
+			}
+			tree := NewMerkleTree(data)
+
+			for i, datum := range data {
+				proof := tree.Proof(i)
+				decoded, err := DecodeMerkleProof(EncodeMerkleProof(proof))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if root := MerkleRootFromProof(datum, decoded); !bytes.Equal(root, tree.Root()) {
+					t.Errorf("proof of leaf %d leads to %x, want %x", i, root, tree.Root())
+				}
+			}
+		})
+	}
+
+	a, b := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b"))
+	root := sha256.Sum256(append(a[:], b[:]...))
+	if got := NewMerkleTree([][]byte{[]byte("a"), []byte("b")}).Root(); !bytes.Equal(got, root[:]) {
+		t.Errorf("root of two leaves is %x, want %x", got, root)
+	}
+}
+
+func TestDecodeMerkleProof(t *testing.T) {
+	step := bytes.Repeat([]byte{0xab}, sha256.Size)
+
+	cases := []struct {
+		name    string
+		encoded string
+		ok      bool
+	}{
+		{"empty", "", true},
+		{"two steps", fmt.Sprintf("00%x01%x", step, step), true},
+		{"not hex", "zz", false},
+		{"short step", fmt.Sprintf("00%x", step[1:]), false},
+		{"bad side", fmt.Sprintf("02%x", step), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := DecodeMerkleProof(c.encoded)
+			if (err == nil) != c.ok {
+				t.Errorf("got %v, want ok %v", err, c.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyMerkleProof(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := testPayment(bc, alice, bob, 7)
+	block := newTestBlock(genesis, miner, payment)
+	err = bc.AddBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockHash, proof, err := bc.GetMerkleProof(payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blockHash, block.Hash) {
+		t.Fatalf("proof is for block %x, want %x", blockHash, block.Hash)
+	}
+	tampered := append([]MerkleProofStep{}, proof...)
+	tampered[0] = MerkleProofStep{!proof[0].Left, proof[0].Hash}
+
+	cases := []struct {
+		name  string
+		block []byte
+		txID  []byte
+		proof []MerkleProofStep
+		want  bool
+	}{
+		{"valid", block.Hash, payment.ID, proof, true},
+		{"other transaction", block.Hash, block.Transactions[0].ID, proof, false},
+		{"other block", genesis.Hash, payment.ID, proof, false},
+		{"tampered proof", block.Hash, payment.ID, tampered, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ok, err := bc.VerifyMerkleProof(c.block, c.txID, c.proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != c.want {
+				t.Errorf("got %v, want %v", ok, c.want)
+			}
+		})
+	}
+
+	_, err = bc.VerifyMerkleProof([]byte("unknown block"), payment.ID, proof)
+	if err == nil {
+		t.Error("proof against an unknown block was checked")
+	}
+
+	// the two children of the root hash to it, so they would pass for a
+	// 64 byte ID with an empty proof
+	inner := append(append([]byte{}, block.Transactions[0].ID...), payment.ID...)
+	_, err = bc.VerifyMerkleProof(block.Hash, inner, nil)
+	if err == nil {
+		t.Error("proof of a 64 byte ID was checked")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ChainParams holds the rules and settings that differ between networks.
+// Chains of different networks can't be mixed: their genesis blocks,
+// addresses and data directories are all distinct.
+type ChainParams struct {
+	Name string
+	// DataDir holds the chain database and the wallets of the network. It
+	// is relative to the working directory.
+	DataDir string
+
+	GenesisCoinbaseData string
+	// AddressVersion starts the addresses of wallets,
+	// MultisigAddressVersion the ones of multisig scripts and
+	// ScriptHashVersion the ones of script hashes
+	AddressVersion         byte
+	MultisigAddressVersion byte
+	ScriptHashVersion      byte
+
+	// GenesisBits is the difficulty of the genesis block. Later blocks are
+	// retargeted every RetargetInterval blocks so that they come
+	// TargetBlockTime seconds apart, unless NoRetargeting is set.
+	GenesisBits      int
+	RetargetInterval int
+	TargetBlockTime  int
+	NoRetargeting    bool
+
+	Subsidy          int
+	HalvingInterval  int
+	CoinbaseMaturity int
+}
+
+var MainNetParams = ChainParams{
+	Name:                   "main",
+	DataDir:                "",
+	GenesisCoinbaseData:    "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+	AddressVersion:         0x00,
+	MultisigAddressVersion: 0x0f,
+	ScriptHashVersion:      0x05,
+	GenesisBits:            22,
+	RetargetInterval:       10,
+	TargetBlockTime:        10,
+	Subsidy:                50,
+	HalvingInterval:        210000,
+	CoinbaseMaturity:       100,
+}
+
+// TestNetParams is the staging network: it follows the main network rules
+// at a lower difficulty
+var TestNetParams = ChainParams{
+	Name:                   "test",
+	DataDir:                "testnet",
+	GenesisCoinbaseData:    "Test network genesis block",
+	AddressVersion:         0x6f,
+	MultisigAddressVersion: 0xd1,
+	ScriptHashVersion:      0xc4,
+	GenesisBits:            18,
+	RetargetInterval:       10,
+	TargetBlockTime:        10,
+	Subsidy:                50,
+	HalvingInterval:        210000,
+	CoinbaseMaturity:       100,
+}
+
+// RegTestParams is meant for local development: blocks are mined at a fixed
+// low difficulty, rewards halve quickly and can be spent in the next block
+var RegTestParams = ChainParams{
+	Name:                   "regtest",
+	DataDir:                "regtest",
+	GenesisCoinbaseData:    "Regression test network genesis block",
+	AddressVersion:         0x7a,
+	MultisigAddressVersion: 0x7c,
+	ScriptHashVersion:      0x7b,
+	GenesisBits:            8,
+	RetargetInterval:       10,
+	TargetBlockTime:        10,
+	NoRetargeting:          true,
+	Subsidy:                50,
+	HalvingInterval:        150,
+	CoinbaseMaturity:       1,
+}
+
+// params are the parameters of the network the program runs on
+var params = &MainNetParams
+
+func NetworkParams(name string) (*ChainParams, error) {
+	for _, p := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown network %q", name)
+}
+
+// path returns the location of file in the network's data directory,
+// creating the directory if needed
+func (p *ChainParams) path(file string) string {
+	if p.DataDir == "" {
+		return file
+	}
+
+	err := os.MkdirAll(p.DataDir, 0700)
+	if err != nil {
+		log.Panic(err)
+	}
+	return filepath.Join(p.DataDir, file)
+}
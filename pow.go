@@ -1,37 +1,25 @@
 package main
 
 import (
-	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"math/big"
 )
 
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
-func NewProofOfWork(b *Block) *ProofOfWork {
+func NewProofOfWork(h *BlockHeader) *ProofOfWork {
 	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
-	pow := &ProofOfWork{b, target}
+	target.Lsh(target, uint(256-h.Bits))
+	pow := &ProofOfWork{h, target}
 	return pow
 }
 
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransaction(),
-			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	return pow.header.hashData(nonce)
 }
 
 func (pow *ProofOfWork) Solve() (int, []byte) {
@@ -56,7 +44,7 @@ func (pow *ProofOfWork) Solve() (int, []byte) {
 }
 
 func (pow *ProofOfWork) Validate() bool {
-	data := pow.prepareData(pow.block.Nonce)
+	data := pow.prepareData(pow.header.Nonce)
 	hash := sha256.Sum256(data)
 	var hashInt big.Int
 	hashInt.SetBytes(hash[:])
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math/big"
+)
+
+const workBucket = "blockwork"
+
+// blockWork is the expected number of hashes needed to mine a block at bits
+func blockWork(bits int) *big.Int {
+	work := big.NewInt(1)
+	return work.Lsh(work, uint(bits))
+}
+
+// getWork returns the cumulative work of the chain ending at hash, or zero
+// for an unknown block
+func getWork(tx StoreTx, hash []byte) *big.Int {
+	work := new(big.Int)
+	if data := tx.Get(workBucket, hash); data != nil {
+		work.SetBytes(data)
+	}
+	return work
+}
+
+func putWork(tx StoreTx, hash []byte, work *big.Int) error {
+	return tx.Put(workBucket, hash, work.Bytes())
+}
+
+// isMainChain reports whether block is part of the active chain
+func isMainChain(tx StoreTx, block *Block) bool {
+	hash := tx.Get(heightIndexBucket, IntToHex(int64(block.Height)))
+	return bytes.Equal(hash, block.Hash)
+}
+
+// reorganize makes newTip the active tip. Blocks of the old chain above the
+// fork point are disconnected and the blocks of the new branch are
+// validated and connected in order. It runs inside the caller's store
+// transaction, so a branch that fails validation leaves nothing behind.
+func reorganize(tx StoreTx, newTip *Block) error {
+	var attach []*Block
+	var fork *Block
+
+	for block := newTip; ; {
+		if isMainChain(tx, block) {
+			fork = block
+			break
+		}
+		attach = append(attach, block)
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+
+		var err error
+		block, err = getBlock(tx, block.PrevBlockHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	tip := getTip(tx)
+	for len(tip) > 0 && (fork == nil || !bytes.Equal(tip, fork.Hash)) {
+		block, err := getBlock(tx, tip)
+		if err != nil {
+			return err
+		}
+
+		err = disconnectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+		tip = block.PrevBlockHash
+	}
+
+	for i := len(attach) - 1; i >= 0; i-- {
+		err := checkBlockTransactions(tx, attach[i])
+		if err != nil {
+			return err
+		}
+
+		err = connectBlock(tx, attach[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return setTip(tx, newTip.Hash)
+}
+
+// disconnectBlock reverts connectBlock for the current tip. The outputs it
+// spent are restored from the transaction index, so it has to run before
+// the block's own transactions are dropped from the index.
+func disconnectBlock(tx StoreTx, block *Block) error {
+	err := unindexAddresses(tx, block)
+	if err != nil {
+		return err
+	}
+
+	err = undoUTXO(tx, block)
+	if err != nil {
+		return err
+	}
+
+	err = unindexTransactions(tx, block)
+	if err != nil {
+		return err
+	}
+
+	return unindexHeight(tx, block)
+}
+
+// ReindexWork recomputes the cumulative work of the blocks of the active
+// chain
+func (bc *Blockchain) ReindexWork() {
+	err := bc.store.Batch(func(tx StoreTx) error {
+		var blocks []*Block
+		err := walkChain(tx, func(block *Block) error {
+			blocks = append(blocks, block)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		work := new(big.Int)
+		for i := len(blocks) - 1; i >= 0; i-- {
+			work.Add(work, blockWork(blocks[i].Bits))
+			err = putWork(tx, blocks[i].Hash, work)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
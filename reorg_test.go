@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAddBlockReorganize(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+
+	// the a branch pays bob in a1, the b branch forks off genesis
+	cases := []struct {
+		name   string
+		blocks []string
+		err    error
+		tip    string
+		paid   bool
+	}{
+		{"side branch", []string{"a1", "b1"}, nil, "a1", true},
+		{"longer branch", []string{"a1", "b1", "b2"}, nil, "b2", false},
+		{"equal work", []string{"a1", "b1", "b2", "a2"}, nil, "b2", false},
+		{"back to first branch", []string{"a1", "b1", "b2", "a2", "a3"}, nil, "a3", true},
+		{"invalid branch", []string{"a1", "b1", "bad2"}, ErrMissingInput, "a1", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := newTestChain(alice)
+			genesis, err := bc.GetBlock(bc.tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payment := testPayment(bc, alice, bob, 7)
+			blocks := make(map[string]*Block)
+			blocks["a1"] = newTestBlock(genesis, miner, payment)
+			blocks["a2"] = newTestBlock(blocks["a1"], miner)
+			blocks["a3"] = newTestBlock(blocks["a2"], miner)
+			blocks["b1"] = newTestBlock(genesis, miner)
+			blocks["b2"] = newTestBlock(blocks["b1"], miner)
+			// spends the reward of a1, which the b branch does not have
+			reward := blocks["a1"].Transactions[0]
+			blocks["bad2"] = newTestBlock(blocks["b1"], miner, testSpend(reward, miner, bob, reward.Vout[0].Value))
+
+			for i, name := range c.blocks {
+				err = bc.AddBlock(blocks[name])
+				if i < len(c.blocks)-1 && err != nil {
+					t.Fatalf("adding %s: %v", name, err)
+				}
+			}
+			if !errors.Is(err, c.err) {
+				t.Fatalf("got error %v, want %v", err, c.err)
+			}
+
+			if !bytes.Equal(bc.tip, blocks[c.tip].Hash) {
+				t.Errorf("tip is %x, want %s", bc.tip, c.tip)
+			}
+			_, _, err = bc.FindTransaction(payment.ID)
+			if (err == nil) != c.paid {
+				t.Errorf("payment indexed: %v, want %v", err == nil, c.paid)
+			}
+			wantBob := 0
+			if c.paid {
+				wantBob = 7
+			}
+			if got := testBalance(bc, bob); got != wantBob {
+				t.Errorf("bob has %d, want %d", got, wantBob)
+			}
+			if got := testBalance(bc, alice); got != 50-wantBob {
+				t.Errorf("alice has %d, want %d", got, 50-wantBob)
+			}
+
+			updated := testUTXOSet(t, bc)
+			UTXOSet{bc}.Reindex()
+			if reindexed := testUTXOSet(t, bc); !reflect.DeepEqual(updated, reindexed) {
+				t.Errorf("UTXO set %v differs from reindexed %v", updated, reindexed)
+			}
+		})
+	}
+}
+
+func TestAddStoredBlock(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment := testPayment(bc, alice, bob, 7)
+	a1 := newTestBlock(genesis, miner)
+	b1 := newTestBlock(genesis, miner, payment, testSpend(payment, bob, miner, 7))
+	b2 := newTestBlock(b1, miner)
+	err = bc.AddBlock(a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a side chain body that repeats its last transaction has the hash of
+	// b1, as one stored before the body was checked could
+	mutated := *b1
+	mutated.Transactions = append(mutated.Transactions, b1.Transactions[2])
+	err = bc.store.Batch(func(tx StoreTx) error {
+		return putBlock(tx, &mutated)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []*Block{b1, b2} {
+		err = bc.AddBlock(block)
+		if err != nil {
+			t.Fatalf("adding block %x: %v", block.Hash, err)
+		}
+	}
+	if !bytes.Equal(bc.tip, b2.Hash) {
+		t.Errorf("tip is %x, want %x", bc.tip, b2.Hash)
+	}
+	if got := testBalance(bc, miner); got != blockSubsidy(1)+blockSubsidy(2)+7 {
+		t.Errorf("miner has %d, want %d", got, blockSubsidy(1)+blockSubsidy(2)+7)
+	}
+}
+
+// testUTXOSet reads the whole UTXO set keyed by transaction ID
+func testUTXOSet(t *testing.T, bc *Blockchain) map[string]TXOutputs {
+	set := make(map[string]TXOutputs)
+	err := bc.store.View(func(tx StoreTx) error {
+		return tx.ForEach(utxoBucket, func(k, v []byte) error {
+			set[hex.EncodeToString(k)] = DeserializeOutputs(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+// Outputs are locked by a script, ScriptPubKey, and inputs unlock them with
+// another one, ScriptSig. A script is a sequence of opcodes, each of which
+// may carry pushed data. To spend an output, the ScriptSig of the input is
+// run first and may only push data; the ScriptPubKey then runs on the stack
+// it left behind. The output is unlocked if neither fails and the value on
+// top of the stack is true.
+const (
+	opFalse     = 0x00
+	opPushData1 = 0x4c
+	opPushData2 = 0x4d
+	opPushData4 = 0x4e
+	opTrue      = 0x51
+	op16        = 0x60
+
+	opIf             = 0x63
+	opNotIf          = 0x64
+	opElse           = 0x67
+	opEndIf          = 0x68
+	opVerify         = 0x69
+	opReturn         = 0x6a
+	opDrop           = 0x75
+	opDup            = 0x76
+	opSize           = 0x82
+	opEqual          = 0x87
+	opEqualVerify    = 0x88
+	opSHA256         = 0xa8
+	opHash160        = 0xa9
+	opCheckSig       = 0xac
+	opCheckSigVerify = 0xad
+
+	opCheckMultiSig       = 0xae
+	opCheckMultiSigVerify = 0xaf
+	opCheckLockTimeVerify = 0xb1
+)
+
+// Opcodes 0x01 to 0x4b push the next that many bytes
+const opMaxDirectPush = 0x4b
+
+var opcodeNames = map[byte]string{
+	opFalse:          "OP_0",
+	opIf:             "OP_IF",
+	opNotIf:          "OP_NOTIF",
+	opElse:           "OP_ELSE",
+	opEndIf:          "OP_ENDIF",
+	opVerify:         "OP_VERIFY",
+	opReturn:         "OP_RETURN",
+	opDrop:           "OP_DROP",
+	opDup:            "OP_DUP",
+	opSize:           "OP_SIZE",
+	opEqual:          "OP_EQUAL",
+	opEqualVerify:    "OP_EQUALVERIFY",
+	opSHA256:         "OP_SHA256",
+	opHash160:        "OP_HASH160",
+	opCheckSig:       "OP_CHECKSIG",
+	opCheckSigVerify: "OP_CHECKSIGVERIFY",
+
+	opCheckMultiSig:       "OP_CHECKMULTISIG",
+	opCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	opCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+const (
+	maxScriptSize        = 10000
+	maxScriptElementSize = 520
+	maxScriptStackSize   = 1000
+	maxScriptNumberSize  = 4
+	maxMultisigKeys      = 16
+
+	// lock times may need five bytes to reach past 2^31
+	maxLockTimeNumberSize = 5
+)
+
+var (
+	errScriptMalformed   = errors.New("Script is malformed")
+	errScriptTooLarge    = errors.New("Script is too large")
+	errScriptNotPushOnly = errors.New("Unlocking script does more than push data")
+	errScriptOpcode      = errors.New("Script uses an unknown opcode")
+	errScriptReturn      = errors.New("Script returned early")
+	errScriptStack       = errors.New("Script stack has too few items")
+	errScriptStackSize   = errors.New("Script stack is too large")
+	errScriptNumber      = errors.New("Script number is out of range")
+	errScriptUnbalanced  = errors.New("Script has an unbalanced conditional")
+	errScriptLockTime    = errors.New("Script lock time has not passed")
+	errScriptVerify      = errors.New("Script verification failed")
+	errScriptFalse       = errors.New("Script finished with false on the stack")
+)
+
+// scriptOp is one parsed opcode along with the data it pushes, if any
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+func (op scriptOp) isPush() bool {
+	return op.opcode <= opPushData4
+}
+
+// parseScript splits script into its opcodes
+func parseScript(script []byte) ([]scriptOp, error) {
+	var ops []scriptOp
+
+	for len(script) > 0 {
+		opcode := script[0]
+		script = script[1:]
+
+		size := 0
+		switch {
+		case opcode <= opMaxDirectPush:
+			size = int(opcode)
+		case opcode == opPushData1 && len(script) >= 1:
+			size = int(script[0])
+			script = script[1:]
+		case opcode == opPushData2 && len(script) >= 2:
+			size = int(binary.LittleEndian.Uint16(script))
+			script = script[2:]
+		case opcode == opPushData4 && len(script) >= 4:
+			size = int(binary.LittleEndian.Uint32(script))
+			script = script[4:]
+		case opcode <= opPushData4:
+			return nil, errScriptMalformed
+		}
+		if size < 0 || size > len(script) {
+			return nil, errScriptMalformed
+		}
+
+		op := scriptOp{opcode, nil}
+		if opcode <= opPushData4 {
+			op.data = script[:size]
+			script = script[size:]
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// appendPushData appends the shortest opcode pushing data to script
+func appendPushData(script []byte, data []byte) []byte {
+	size := len(data)
+	switch {
+	case size == 0:
+		return append(script, opFalse)
+	case size <= opMaxDirectPush:
+		script = append(script, byte(size))
+	case size <= 0xff:
+		script = append(script, opPushData1, byte(size))
+	case size <= 0xffff:
+		var b [2]byte
+		binary.LittleEndian.PutUint16(b[:], uint16(size))
+		script = append(append(script, opPushData2), b[:]...)
+	default:
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(size))
+		script = append(append(script, opPushData4), b[:]...)
+	}
+	return append(script, data...)
+}
+
+// pushedData returns the data pushed by a script made of pushes only
+func pushedData(script []byte) ([][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil, false
+	}
+
+	var data [][]byte
+	for _, op := range ops {
+		if !op.isPush() {
+			return nil, false
+		}
+		data = append(data, op.data)
+	}
+	return data, true
+}
+
+// disassembleScript returns script in a readable form, with opcodes by
+// name and pushed data in hex
+func disassembleScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[malformed] %x", script)
+	}
+
+	var words []string
+	for _, op := range ops {
+		switch {
+		case op.isPush() && len(op.data) > 0:
+			words = append(words, hex.EncodeToString(op.data))
+		case op.opcode >= opTrue && op.opcode <= op16:
+			words = append(words, fmt.Sprintf("OP_%d", op.opcode-opTrue+1))
+		case opcodeNames[op.opcode] != "":
+			words = append(words, opcodeNames[op.opcode])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%x", op.opcode))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// payToPubKeyHashScript locks an output to the owner of the public key
+// hashing to pubKeyHash:
+//
+//	OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG
+func payToPubKeyHashScript(pubKeyHash []byte) []byte {
+	script := []byte{opDup, opHash160}
+	script = appendPushData(script, pubKeyHash)
+	return append(script, opEqualVerify, opCheckSig)
+}
+
+// payToPubKeyHashScriptSig unlocks a pay-to-pubkey-hash output:
+//
+//	<signature> <pubKey>
+func payToPubKeyHashScriptSig(signature, pubKey []byte) []byte {
+	return appendPushData(appendPushData(nil, signature), pubKey)
+}
+
+// extractPubKeyHash returns the public key hash a pay-to-pubkey-hash script
+// locks to
+func extractPubKeyHash(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 5 {
+		return nil, false
+	}
+	if ops[0].opcode != opDup || ops[1].opcode != opHash160 || !ops[2].isPush() ||
+		ops[3].opcode != opEqualVerify || ops[4].opcode != opCheckSig {
+		return nil, false
+	}
+	if !bytes.Equal(script, payToPubKeyHashScript(ops[2].data)) {
+		return nil, false
+	}
+	return ops[2].data, true
+}
+
+// scriptHashScript locks an output to whoever reveals a script hashing to
+// scriptHash and meets its conditions:
+//
+//	OP_HASH160 <scriptHash> OP_EQUAL
+func scriptHashScript(scriptHash []byte) []byte {
+	script := appendPushData([]byte{opHash160}, scriptHash)
+	return append(script, opEqual)
+}
+
+// extractScriptHash returns the hash a pay-to-script-hash script commits to
+func extractScriptHash(script []byte) ([]byte, bool) {
+	if len(script) != ripemd160.Size+3 || !bytes.Equal(script, scriptHashScript(script[2:2+ripemd160.Size])) {
+		return nil, false
+	}
+	return script[2 : 2+ripemd160.Size], true
+}
+
+// multisigScript locks an output to m signatures of the owners of pubKeys,
+// given in the order of the keys:
+//
+//	OP_m <pubKey 1> ... <pubKey n> OP_n OP_CHECKMULTISIG
+func multisigScript(m int, pubKeys [][]byte) []byte {
+	script := []byte{byte(opTrue + m - 1)}
+	for _, pubKey := range pubKeys {
+		script = appendPushData(script, pubKey)
+	}
+	return append(script, byte(opTrue+len(pubKeys)-1), opCheckMultiSig)
+}
+
+// multisigScriptSig unlocks a multisig output:
+//
+//	<signature 1> ... <signature m>
+func multisigScriptSig(signatures [][]byte) []byte {
+	var script []byte
+	for _, signature := range signatures {
+		script = appendPushData(script, signature)
+	}
+	return script
+}
+
+// extractMultisig returns the number of signatures a multisig script
+// requires and its public keys
+func extractMultisig(script []byte) (int, [][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 {
+		return 0, nil, false
+	}
+
+	m := smallInt(ops[0].opcode)
+	n := smallInt(ops[len(ops)-2].opcode)
+	if m < 1 || n < m || n != len(ops)-3 || ops[len(ops)-1].opcode != opCheckMultiSig {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		if !op.isPush() {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+	if !bytes.Equal(script, multisigScript(m, pubKeys)) {
+		return 0, nil, false
+	}
+	return m, pubKeys, true
+}
+
+// htlcScript locks an output to the recipient once they reveal the 32 byte
+// preimage of hash, or to the sender in transactions whose LockTime is at
+// least timeout:
+//
+//	OP_IF
+//	    OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <hash> OP_EQUALVERIFY
+//	    OP_DUP OP_HASH160 <recipient pubKeyHash>
+//	OP_ELSE
+//	    <timeout> OP_CHECKLOCKTIMEVERIFY OP_DROP
+//	    OP_DUP OP_HASH160 <sender pubKeyHash>
+//	OP_ENDIF
+//	OP_EQUALVERIFY OP_CHECKSIG
+func htlcScript(hash, recipient, sender []byte, timeout int64) []byte {
+	script := []byte{opIf, opSize}
+	script = appendScriptNumber(script, sha256.Size)
+	script = append(script, opEqualVerify, opSHA256)
+	script = appendPushData(script, hash)
+	script = append(script, opEqualVerify, opDup, opHash160)
+	script = appendPushData(script, recipient)
+	script = append(script, opElse)
+	script = appendScriptNumber(script, timeout)
+	script = append(script, opCheckLockTimeVerify, opDrop, opDup, opHash160)
+	script = appendPushData(script, sender)
+	return append(script, opEndIf, opEqualVerify, opCheckSig)
+}
+
+// htlcScriptSig unlocks an HTLC output through the recipient's branch when
+// preimage is given and through the sender's one otherwise:
+//
+//	<signature> <pubKey> <preimage> 1
+//	<signature> <pubKey> 0
+func htlcScriptSig(signature, pubKey, preimage []byte) []byte {
+	script := payToPubKeyHashScriptSig(signature, pubKey)
+	if preimage == nil {
+		return appendPushData(script, nil)
+	}
+	script = appendPushData(script, preimage)
+	return appendPushData(script, []byte{1})
+}
+
+// htlcContract holds the terms of an HTLC output
+type htlcContract struct {
+	Hash      []byte
+	Recipient []byte
+	Sender    []byte
+	Timeout   int64
+}
+
+// extractHTLC returns the terms of an HTLC script
+func extractHTLC(script []byte) (htlcContract, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 20 {
+		return htlcContract{}, false
+	}
+
+	timeout := int64(smallInt(ops[11].opcode))
+	if ops[11].isPush() {
+		timeout, err = scriptNumber(ops[11].data, maxLockTimeNumberSize)
+	}
+	contract := htlcContract{ops[5].data, ops[9].data, ops[16].data, timeout}
+	if err != nil || timeout <= 0 || len(contract.Hash) != sha256.Size ||
+		len(contract.Recipient) != ripemd160.Size || len(contract.Sender) != ripemd160.Size {
+		return htlcContract{}, false
+	}
+	if !bytes.Equal(script, htlcScript(contract.Hash, contract.Recipient, contract.Sender, timeout)) {
+		return htlcContract{}, false
+	}
+	return contract, true
+}
+
+// appendScriptNumber appends the shortest opcode pushing n
+func appendScriptNumber(script []byte, n int64) []byte {
+	if n >= 1 && n <= 16 {
+		return append(script, byte(opTrue+n-1))
+	}
+	return appendPushData(script, scriptNumberBytes(n))
+}
+
+// scriptNumberBytes encodes n the way popNumber reads it, in as few bytes
+// as possible
+func scriptNumberBytes(n int64) []byte {
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append(b, byte(n))
+	}
+	switch {
+	case len(b) == 0:
+	case b[len(b)-1]&0x80 != 0 && negative:
+		b = append(b, 0x80)
+	case b[len(b)-1]&0x80 != 0:
+		b = append(b, 0)
+	case negative:
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// smallInt returns the number pushed by opcodes OP_1 to OP_16, or 0
+func smallInt(opcode byte) int {
+	if opcode < opTrue || opcode > op16 {
+		return 0
+	}
+	return int(opcode-opTrue) + 1
+}
+
+// scriptChecker checks scripts against the transaction being verified:
+// checkSig tells if signature is valid for pubKey over it, for the
+// CHECKSIG opcodes, and checkLockTime if its LockTime has reached lockTime,
+// for CHECKLOCKTIMEVERIFY.
+type scriptChecker interface {
+	checkSig(signature, pubKey []byte) bool
+	checkLockTime(lockTime int64) bool
+}
+
+// executeScript runs scriptSig and then scriptPubKey on the resulting stack.
+// For pay-to-script-hash outputs the last item scriptSig pushed is the
+// redeem script: it then runs on the rest of the items scriptSig pushed.
+func executeScript(scriptSig, scriptPubKey []byte, checker scriptChecker) error {
+	ops, err := parseScript(scriptSig)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return errScriptNotPushOnly
+		}
+	}
+
+	var stack scriptStack
+	err = stack.run(scriptSig, checker)
+	if err != nil {
+		return err
+	}
+	redeemStack := append(scriptStack{}, stack...)
+
+	err = stack.run(scriptPubKey, checker)
+	if err != nil {
+		return err
+	}
+	err = stack.popTrue()
+	if err != nil {
+		return err
+	}
+	if _, ok := extractScriptHash(scriptPubKey); !ok {
+		return nil
+	}
+
+	redeemScript, err := redeemStack.pop()
+	if err != nil {
+		return err
+	}
+	err = redeemStack.run(redeemScript, checker)
+	if err != nil {
+		return err
+	}
+	return redeemStack.popTrue()
+}
+
+type scriptStack [][]byte
+
+func (s *scriptStack) push(item []byte) error {
+	if len(item) > maxScriptElementSize {
+		return fmt.Errorf("%w: item of %d bytes", errScriptTooLarge, len(item))
+	}
+	if len(*s) >= maxScriptStackSize {
+		return errScriptStackSize
+	}
+	*s = append(*s, item)
+	return nil
+}
+
+func (s *scriptStack) pop() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, errScriptStack
+	}
+	item := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return item, nil
+}
+
+// popTrue pops the result a script left on top of the stack and fails
+// unless it is true
+func (s *scriptStack) popTrue() error {
+	top, err := s.pop()
+	if err != nil {
+		return err
+	}
+	if !castToBool(top) {
+		return errScriptFalse
+	}
+	return nil
+}
+
+func (s *scriptStack) top() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, errScriptStack
+	}
+	return (*s)[len(*s)-1], nil
+}
+
+func (s *scriptStack) popNumber() (int64, error) {
+	item, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	return scriptNumber(item, maxScriptNumberSize)
+}
+
+// scriptNumber decodes a number of at most maxSize bytes: little endian,
+// with the sign in the high bit of the last byte
+func scriptNumber(item []byte, maxSize int) (int64, error) {
+	if len(item) > maxSize {
+		return 0, errScriptNumber
+	}
+
+	var n int64
+	for i, b := range item {
+		n |= int64(b) << uint(8*i)
+	}
+	if len(item) > 0 && item[len(item)-1]&0x80 != 0 {
+		n &^= int64(0x80) << uint(8*(len(item)-1))
+		n = -n
+	}
+	return n, nil
+}
+
+func (s *scriptStack) pushBool(v bool) error {
+	if v {
+		return s.push([]byte{1})
+	}
+	return s.push(nil)
+}
+
+// run executes script on s. Opcodes between IF or NOTIF, ELSE and ENDIF
+// only run in the branch the condition selects.
+func (s *scriptStack) run(script []byte, checker scriptChecker) error {
+	if len(script) > maxScriptSize {
+		return errScriptTooLarge
+	}
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	// branches tells for each enclosing conditional if its current branch
+	// runs
+	var branches []bool
+	for _, op := range ops {
+		executing := true
+		for _, branch := range branches {
+			executing = executing && branch
+		}
+
+		switch op.opcode {
+		case opIf, opNotIf:
+			var item []byte
+			if executing {
+				item, err = s.pop()
+			}
+			branches = append(branches, executing && castToBool(item) == (op.opcode == opIf))
+		case opElse:
+			if len(branches) == 0 {
+				err = errScriptUnbalanced
+			} else {
+				branches[len(branches)-1] = !branches[len(branches)-1]
+			}
+		case opEndIf:
+			if len(branches) == 0 {
+				err = errScriptUnbalanced
+			} else {
+				branches = branches[:len(branches)-1]
+			}
+		default:
+			if executing {
+				err = s.step(op, checker)
+			}
+		}
+		if err != nil {
+			name := opcodeNames[op.opcode]
+			if name == "" {
+				name = fmt.Sprintf("opcode %x", op.opcode)
+			}
+			return fmt.Errorf("%w at %s", err, name)
+		}
+	}
+	if len(branches) > 0 {
+		return errScriptUnbalanced
+	}
+	return nil
+}
+
+func (s *scriptStack) step(op scriptOp, checker scriptChecker) error {
+	switch {
+	case op.isPush():
+		return s.push(op.data)
+	case op.opcode >= opTrue && op.opcode <= op16:
+		return s.push([]byte{op.opcode - opTrue + 1})
+	}
+
+	switch op.opcode {
+	case opVerify:
+		item, err := s.pop()
+		if err != nil {
+			return err
+		}
+		if !castToBool(item) {
+			return errScriptVerify
+		}
+	case opReturn:
+		return errScriptReturn
+	case opDrop:
+		_, err := s.pop()
+		return err
+	case opDup:
+		item, err := s.pop()
+		if err != nil {
+			return err
+		}
+		*s = append(*s, item)
+		return s.push(item)
+	case opSize:
+		item, err := s.top()
+		if err != nil {
+			return err
+		}
+		return s.push(scriptNumberBytes(int64(len(item))))
+	case opEqual, opEqualVerify:
+		a, err := s.pop()
+		if err != nil {
+			return err
+		}
+		b, err := s.pop()
+		if err != nil {
+			return err
+		}
+		if op.opcode == opEqualVerify {
+			if !bytes.Equal(a, b) {
+				return errScriptVerify
+			}
+			return nil
+		}
+		return s.pushBool(bytes.Equal(a, b))
+	case opSHA256:
+		item, err := s.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(item)
+		return s.push(hash[:])
+	case opHash160:
+		item, err := s.pop()
+		if err != nil {
+			return err
+		}
+		return s.push(HashPubKey(item))
+	case opCheckSig, opCheckSigVerify:
+		pubKey, err := s.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := s.pop()
+		if err != nil {
+			return err
+		}
+		valid := checker.checkSig(signature, pubKey)
+		if op.opcode == opCheckSigVerify {
+			if !valid {
+				return errScriptVerify
+			}
+			return nil
+		}
+		return s.pushBool(valid)
+	case opCheckMultiSig, opCheckMultiSigVerify:
+		valid, err := s.checkMultisig(checker)
+		if err != nil {
+			return err
+		}
+		if op.opcode == opCheckMultiSigVerify {
+			if !valid {
+				return errScriptVerify
+			}
+			return nil
+		}
+		return s.pushBool(valid)
+	case opCheckLockTimeVerify:
+		item, err := s.top()
+		if err != nil {
+			return err
+		}
+		lockTime, err := scriptNumber(item, maxLockTimeNumberSize)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 || !checker.checkLockTime(lockTime) {
+			return errScriptLockTime
+		}
+	default:
+		return errScriptOpcode
+	}
+	return nil
+}
+
+// checkMultisig pops the public keys and signatures of a multisig check and
+// tells if every signature matches one of the keys. Signatures have to
+// come in the order of their keys, so each key is tried once.
+func (s *scriptStack) checkMultisig(checker scriptChecker) (bool, error) {
+	n, err := s.popNumber()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > maxMultisigKeys {
+		return false, errScriptNumber
+	}
+	pubKeys, err := s.popItems(int(n))
+	if err != nil {
+		return false, err
+	}
+
+	m, err := s.popNumber()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, errScriptNumber
+	}
+	signatures, err := s.popItems(int(m))
+	if err != nil {
+		return false, err
+	}
+
+	k := 0
+	for _, signature := range signatures {
+		for k < len(pubKeys) && !checker.checkSig(signature, pubKeys[k]) {
+			k++
+		}
+		if k == len(pubKeys) {
+			return false, nil
+		}
+		k++
+	}
+	return true, nil
+}
+
+// popItems pops n items and returns them in the order they were pushed
+func (s *scriptStack) popItems(n int) ([][]byte, error) {
+	if len(*s) < n {
+		return nil, errScriptStack
+	}
+	items := append([][]byte{}, (*s)[len(*s)-n:]...)
+	*s = (*s)[:len(*s)-n]
+	return items, nil
+}
+
+// castToBool is false for empty items and for items of zero bytes, which
+// may end in the sign bit 0x80
+func castToBool(item []byte) bool {
+	for i, b := range item {
+		if b != 0 {
+			return i != len(item)-1 || b != 0x80
+		}
+	}
+	return false
+}
+
+// verifySignature checks a signature made by Transaction.Sign: r and s
+// followed by the X and Y coordinates of the public key, each half of
+// their byte strings
+func verifySignature(pubKey, signature, hash []byte) bool {
+	if len(signature) == 0 || len(pubKey) == 0 {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(signature)
+	r.SetBytes(signature[:(sigLen / 2)])
+	s.SetBytes(signature[(sigLen / 2):])
+
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:keyLen/2])
+	y.SetBytes(pubKey[keyLen/2:])
+
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	return ecdsa.Verify(&rawPubKey, hash, &r, &s)
+}
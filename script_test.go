@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// testChecker accepts the signatures made by testSignature and lock times
+// up to lockTime
+type testChecker struct {
+	lockTime int64
+}
+
+func testSignature(pubKey []byte) []byte {
+	return append([]byte("signature of "), pubKey...)
+}
+
+func (c testChecker) checkSig(signature, pubKey []byte) bool {
+	return bytes.Equal(signature, testSignature(pubKey))
+}
+
+func (c testChecker) checkLockTime(lockTime int64) bool {
+	return lockTime <= c.lockTime
+}
+
+// scriptHashSig unlocks a pay-to-script-hash output of redeemScript
+func scriptHashSig(scriptSig, redeemScript []byte) []byte {
+	return appendPushData(append([]byte{}, scriptSig...), redeemScript)
+}
+
+func TestExecuteScript(t *testing.T) {
+	keys := [][]byte{[]byte("first key"), []byte("second key"), []byte("third key")}
+	sigs := make([][]byte, len(keys))
+	for i, key := range keys {
+		sigs[i] = testSignature(key)
+	}
+
+	p2pkh := payToPubKeyHashScript(HashPubKey(keys[0]))
+	one := appendPushData(nil, []byte{1})
+
+	multisig := multisigScript(2, keys)
+	otherMultisig := multisigScript(2, keys[1:])
+	p2sh := scriptHashScript(HashPubKey(multisig))
+
+	preimage := []byte("0123456789abcdef0123456789abcdef")
+	hash := sha256.Sum256(preimage)
+	wrongPreimage := []byte("0123456789abcdef0123456789abcdeX")
+	htlc := htlcScript(hash[:], HashPubKey(keys[1]), HashPubKey(keys[2]), 100)
+	htlcP2SH := scriptHashScript(HashPubKey(htlc))
+	claim := htlcScriptSig(sigs[1], keys[1], preimage)
+	refund := htlcScriptSig(sigs[2], keys[2], nil)
+
+	cases := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+		lockTime     int64
+		err          error
+	}{
+		{"p2pkh", payToPubKeyHashScriptSig(sigs[0], keys[0]), p2pkh, 0, nil},
+		{"p2pkh wrong key", payToPubKeyHashScriptSig(sigs[1], keys[1]), p2pkh, 0, errScriptVerify},
+		{"p2pkh bad signature", payToPubKeyHashScriptSig(sigs[1], keys[0]), p2pkh, 0, errScriptFalse},
+		{"p2pkh not push only", append(payToPubKeyHashScriptSig(sigs[0], keys[0]), opDup), p2pkh, 0, errScriptNotPushOnly},
+
+		{"multisig", multisigScriptSig(sigs[:2]), multisig, 0, nil},
+		{"multisig skipped key", multisigScriptSig([][]byte{sigs[0], sigs[2]}), multisig, 0, nil},
+		{"multisig out of order", multisigScriptSig([][]byte{sigs[1], sigs[0]}), multisig, 0, errScriptFalse},
+		{"multisig same key twice", multisigScriptSig([][]byte{sigs[0], sigs[0]}), multisig, 0, errScriptFalse},
+		{"multisig too few signatures", multisigScriptSig(sigs[:1]), multisig, 0, errScriptStack},
+		{"p2sh multisig", scriptHashSig(multisigScriptSig(sigs[1:]), multisig), p2sh, 0, nil},
+		{"p2sh wrong redeem script", scriptHashSig(multisigScriptSig(sigs[1:]), otherMultisig), p2sh, 0, errScriptFalse},
+		{"p2sh redeem script fails", scriptHashSig(multisigScriptSig([][]byte{sigs[2], sigs[1]}), multisig), p2sh, 0, errScriptFalse},
+
+		{"htlc claim", claim, htlc, 0, nil},
+		{"htlc wrong preimage", htlcScriptSig(sigs[1], keys[1], wrongPreimage), htlc, 0, errScriptVerify},
+		{"htlc short preimage", htlcScriptSig(sigs[1], keys[1], preimage[1:]), htlc, 0, errScriptVerify},
+		{"htlc claim by sender", htlcScriptSig(sigs[2], keys[2], preimage), htlc, 0, errScriptVerify},
+		{"htlc refund", refund, htlc, 100, nil},
+		{"htlc early refund", refund, htlc, 99, errScriptLockTime},
+		{"htlc refund by recipient", htlcScriptSig(sigs[1], keys[1], nil), htlc, 100, errScriptVerify},
+		{"p2sh htlc claim", scriptHashSig(claim, htlc), htlcP2SH, 0, nil},
+		{"p2sh htlc early refund", scriptHashSig(refund, htlc), htlcP2SH, 99, errScriptLockTime},
+
+		{"true", nil, []byte{opTrue}, 0, nil},
+		{"false", nil, []byte{opFalse}, 0, errScriptFalse},
+		{"empty stack", nil, nil, 0, errScriptStack},
+		{"return", one, []byte{opReturn}, 0, errScriptReturn},
+		{"unknown opcode", one, []byte{0xff}, 0, errScriptOpcode},
+		{"truncated push", one, []byte{2, 1}, 0, errScriptMalformed},
+		{"equal verify", appendPushData(appendPushData(nil, []byte("a")), []byte("a")), []byte{opEqualVerify, opTrue}, 0, nil},
+		{"if else", one, []byte{opIf, opFalse, opElse, opTrue, opEndIf}, 0, errScriptFalse},
+		{"else branch", appendPushData(nil, nil), []byte{opIf, opFalse, opElse, opTrue, opEndIf}, 0, nil},
+		{"unbalanced if", one, []byte{opIf, opTrue}, 0, errScriptUnbalanced},
+		{"unbalanced else", one, []byte{opElse, opTrue}, 0, errScriptUnbalanced},
+		{"not equal", appendPushData(appendPushData(nil, []byte("a")), []byte("b")), []byte{opEqual}, 0, errScriptFalse},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := executeScript(c.scriptSig, c.scriptPubKey, testChecker{c.lockTime})
+			if !errors.Is(err, c.err) {
+				t.Errorf("got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestExtractMultisig(t *testing.T) {
+	keys := [][]byte{[]byte("first key"), []byte("second key")}
+
+	m, pubKeys, ok := extractMultisig(multisigScript(2, keys))
+	if !ok || m != 2 || len(pubKeys) != 2 || !bytes.Equal(pubKeys[1], keys[1]) {
+		t.Errorf("got %d, %q, %v, want 2, %q", m, pubKeys, ok, keys)
+	}
+	if _, _, ok := extractMultisig(multisigScript(3, keys)); ok {
+		t.Error("3 of 2 script is a multisig script")
+	}
+	if _, _, ok := extractMultisig(payToPubKeyHashScript(HashPubKey(keys[0]))); ok {
+		t.Error("pay-to-pubkey-hash script is a multisig script")
+	}
+}
+
+func TestExtractHTLC(t *testing.T) {
+	hash := sha256.Sum256([]byte("preimage"))
+	recipient, sender := HashPubKey([]byte("recipient")), HashPubKey([]byte("sender"))
+
+	for _, timeout := range []int64{1, 16, 17, 1000, 1700000000} {
+		contract, ok := extractHTLC(htlcScript(hash[:], recipient, sender, timeout))
+		if !ok || contract.Timeout != timeout || !bytes.Equal(contract.Hash, hash[:]) ||
+			!bytes.Equal(contract.Recipient, recipient) || !bytes.Equal(contract.Sender, sender) {
+			t.Errorf("timeout %d: got %+v, %v", timeout, contract, ok)
+		}
+	}
+	if _, ok := extractHTLC(htlcScript(hash[:16], recipient, sender, 100)); ok {
+		t.Error("script with a short hash is an HTLC")
+	}
+	if _, ok := extractHTLC(payToPubKeyHashScript(recipient)); ok {
+		t.Error("pay-to-pubkey-hash script is an HTLC")
+	}
+}
+
+func TestAppendPushData(t *testing.T) {
+	for _, size := range []int{0, 1, opMaxDirectPush, opMaxDirectPush + 1, 255, 256, maxScriptElementSize} {
+		data := bytes.Repeat([]byte{1}, size)
+		pushed, ok := pushedData(appendPushData(nil, data))
+		if !ok || len(pushed) != 1 || !bytes.Equal(pushed[0], data) {
+			t.Errorf("push of %d bytes reads back as %x, %v", size, pushed, ok)
+		}
+	}
+}
+
+func TestExtractPubKeyHash(t *testing.T) {
+	pubKeyHash := HashPubKey([]byte("key"))
+	script := payToPubKeyHashScript(pubKeyHash)
+
+	if got, ok := extractPubKeyHash(script); !ok || !bytes.Equal(got, pubKeyHash) {
+		t.Errorf("got %x, %v, want %x", got, ok, pubKeyHash)
+	}
+	if _, ok := extractPubKeyHash(append(script, opTrue)); ok {
+		t.Error("script with an extra opcode has a public key hash")
+	}
+	if _, ok := extractPubKeyHash([]byte{opTrue}); ok {
+		t.Error("OP_TRUE has a public key hash")
+	}
+}
+
+func TestExtractScriptHash(t *testing.T) {
+	scriptHash := HashPubKey([]byte("script"))
+
+	if got, ok := extractScriptHash(scriptHashScript(scriptHash)); !ok || !bytes.Equal(got, scriptHash) {
+		t.Errorf("got %x, %v, want %x", got, ok, scriptHash)
+	}
+	if _, ok := extractScriptHash(scriptHashScript(scriptHash[1:])); ok {
+		t.Error("script with a short hash pays to a script hash")
+	}
+	if _, ok := extractScriptHash(payToPubKeyHashScript(scriptHash)); ok {
+		t.Error("pay-to-pubkey-hash script pays to a script hash")
+	}
+}
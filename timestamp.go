@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// timeNow is the clock new blocks are stamped with and the future drift
+// limit is checked against. Tests replace it to control time.
+var timeNow = time.Now
+
+// medianTimePast returns the median timestamp of header and up to
+// medianTimeSpan-1 of its ancestors. A block built on header has to be
+// stamped later than that.
+func medianTimePast(tx StoreTx, header *BlockHeader) (int64, error) {
+	var timestamps []int64
+
+	for header != nil && len(timestamps) < medianTimeSpan {
+		timestamps = append(timestamps, header.Timestamp)
+		if len(header.PrevBlockHash) == 0 {
+			break
+		}
+
+		var err error
+		header, err = getHeader(tx, header.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
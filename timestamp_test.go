@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestBlockAt mines a block like newTestBlock, stamped with timestamp
+func newTestBlockAt(parent *Block, miner *Wallet, timestamp int64, txs ...*Transaction) *Block {
+	height := parent.Height + 1
+	coinbase := NewCoinbaseTX(string(miner.GetAddress()), "", height, blockSubsidy(height))
+	return newBlockAt(append([]*Transaction{coinbase}, txs...), parent.Hash, height, params.GenesisBits, timestamp)
+}
+
+func TestMedianTimePast(t *testing.T) {
+	miner := NewWallet()
+	bc := newTestChain(miner)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// block i is stamped 10*i seconds after genesis
+	tip := genesis
+	for height := 1; height <= medianTimeSpan+2; height++ {
+		tip = newTestBlockAt(tip, miner, genesis.Timestamp+int64(10*height))
+		err = bc.AddBlock(tip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first := height - medianTimeSpan + 1
+		if first < 0 {
+			first = 0
+		}
+		median := first + (height-first+1)/2
+		want := genesis.Timestamp + int64(10*median)
+
+		var mtp int64
+		err = bc.store.View(func(tx StoreTx) error {
+			mtp, err = medianTimePast(tx, &tip.BlockHeader)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mtp != want {
+			t.Errorf("median time past at height %d is %d, want %d", height, mtp, want)
+		}
+	}
+}
+
+func TestBlockBeforeTip(t *testing.T) {
+	miner := NewWallet()
+	bc := newTestChain(miner)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b1 := newTestBlockAt(genesis, miner, genesis.Timestamp+100)
+	b2 := newTestBlockAt(b1, miner, genesis.Timestamp+200)
+	for _, block := range []*Block{b1, b2} {
+		err = bc.AddBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the median of the last three blocks is b1, so b3 may be stamped
+	// before b2 but not at or before b1
+	err = bc.AddBlock(newTestBlockAt(b2, miner, b1.Timestamp))
+	if !errors.Is(err, ErrTimeTooOld) {
+		t.Errorf("block at the median time past: got %v, want %v", err, ErrTimeTooOld)
+	}
+	err = bc.AddBlock(newTestBlockAt(b2, miner, b1.Timestamp+1))
+	if err != nil {
+		t.Errorf("block before its parent: %v", err)
+	}
+}
@@ -3,82 +3,265 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
-	"math/big"
 	"os"
+	"strings"
 )
 
-const subsidy = 50
+// blockSubsidy is the newly minted value a block at height may pay out. It
+// starts at the network's Subsidy and halves every HalvingInterval blocks
+// until it reaches zero, which caps the total supply at a little under
+// 2*Subsidy*HalvingInterval.
+func blockSubsidy(height int) int {
+	halvings := height / params.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return params.Subsidy >> uint(halvings)
+}
 
+// txVersion is the version of newly created transactions. Their ID is the
+// hash of their binary encoding, see encoding.go. Version 0 transactions
+// predate it and were identified by the hash of their gob encoding, which
+// depends on the types gob had seen before in the process. Their IDs can't
+// be computed again, so they are kept as stored.
+const txVersion = 2
+
+// scriptTxVersion is the first version whose encoding carries the scripts of
+// inputs and outputs. Older transactions could only pay to a public key
+// hash, and their encoding holds the signature and public key pushed by
+// each ScriptSig and the public key hash each ScriptPubKey locks to.
+const scriptTxVersion = 2
+
+// Transaction moves value from the outputs its inputs spend to new
+// outputs. It can not be included in a block before LockTime, unless
+// LockTime is 0.
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Version  int
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime int64
 }
 
+// TXInput spends output Vout of transaction Txid. ScriptSig unlocks the
+// output, see script.go. Sequence can hold a relative lock on that output,
+// see sequenceLockMask.
 type TXInput struct {
 	Txid      []byte
 	Vout      int
-	Signature []byte
-	PubKey    []byte
+	ScriptSig []byte
+	Sequence  uint32
 }
 
+// An input with sequenceFinal opts out of both the transaction's LockTime
+// and a relative lock. Otherwise, unless sequenceLockDisable is set, the
+// bits in sequenceLockMask are a relative lock: the number of blocks, or of
+// 2^sequenceGranularity second units when sequenceLockTypeTime is set, that
+// have to pass after the spent output was confirmed.
+const (
+	sequenceFinal        = 0xffffffff
+	sequenceLockDisable  = 1 << 31
+	sequenceLockTypeTime = 1 << 22
+	sequenceLockMask     = 0xffff
+	sequenceGranularity  = 9
+)
+
+// TXOutput holds Value until an input meets the conditions of ScriptPubKey
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	ScriptPubKey []byte
+}
+
+// TXOutputs holds the still unspent outputs of one transaction keyed by
+// their index in Vout, along with the height of the block that created them
+// and whether they come from a coinbase
+type TXOutputs struct {
+	Outputs  map[int]TXOutput
+	Height   int
+	Coinbase bool
 }
 
-func NewCoinbaseTX(to, data string) *Transaction {
+// IsMature tells if outs may be spent by a transaction in a block at height.
+// Coinbase outputs have to be CoinbaseMaturity blocks deep.
+func (outs TXOutputs) IsMature(height int) bool {
+	return !outs.Coinbase || height-outs.Height >= params.CoinbaseMaturity
+}
+
+// NewCoinbaseTX creates a coinbase for the block at height paying value to
+// the address to. value is the block subsidy plus the fees of the
+// transactions of the block. The input script pushes the height, which
+// keeps coinbase IDs unique, and then data or random bytes.
+func NewCoinbaseTX(to, data string, height, value int) *Transaction {
 	if data == "" {
-		data = fmt.Sprintf("Reward to '%s'", to)
+		randData := make([]byte, 20)
+		_, err := rand.Read(randData)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		data = fmt.Sprintf("%x", randData)
 	}
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
-	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
-	tx.Hash()
+	scriptSig := appendPushData(appendPushData(nil, IntToHex(int64(height))), []byte(data))
+	txin := TXInput{[]byte{}, -1, scriptSig, 0}
+	txout := NewTXOutput(value, to)
+	tx := Transaction{nil, txVersion, []TXInput{txin}, []TXOutput{*txout}, 0}
+	tx.ID = tx.Hash()
 	return &tx
 }
 
-/*
-func (in *TXInput) CanUnlockOutputWith(unlockingData string) bool {
-	return in.ScriptSig == unlockingData
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
 }
 
-func (out *TXOutput) CanBeUnlockedWith(unlockingData string) bool {
-	return out.ScriptPubKey == unlockingData
+// CoinbaseHeight returns the block height committed by the first push of
+// the coinbase input, which has to hold exactly 8 bytes
+func (tx *Transaction) CoinbaseHeight() (int, bool) {
+	if !tx.IsCoinbase() {
+		return 0, false
+	}
+	pushes, ok := pushedData(tx.Vin[0].ScriptSig)
+	if !ok || len(pushes) == 0 || len(pushes[0]) != 8 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint64(pushes[0])), true
 }
-*/
 
-func (tx *Transaction) IsCoinbase() bool {
-	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
+// NewUTXOTransaction creates a transaction sending amount from one address
+// to another. It pays fee, or feeRate per byte of the serialized
+// transaction when feeRate is set, and returns the rest to from as change.
+// lockTime is the transaction's LockTime.
+func NewUTXOTransaction(from, to string, amount, fee, feeRate int, lockTime int64, UTXOSet *UTXOSet) *Transaction {
+	wallets, err := NewWallets()
+	if err != nil {
+		log.Panic(err)
+	}
+	wallet := wallets.GetWallet(from)
+
+	for {
+		tx := newTransferTransaction(&wallet, from, *NewTXOutput(amount, to), fee, lockTime, UTXOSet)
+		if feeRate == 0 {
+			return tx
+		}
+
+		// more inputs make the transaction bigger, so select coins again
+		// until the fee covers the size
+		required := feeRate * tx.Size()
+		if fee >= required {
+			return tx
+		}
+		fee = required
+	}
 }
 
-func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+func newTransferTransaction(wallet *Wallet, from string, payment TXOutput, fee int, lockTime int64, UTXOSet *UTXOSet) *Transaction {
+	tx := newUnsignedTransaction(from, payment, fee, lockTime, UTXOSet)
+	UTXOSet.Blockchain.SignTransaction(tx, wallet.PrivateKey)
+	tx.ID = tx.Hash()
 
+	return tx
+}
+
+// NewHTLCTransaction creates a transaction locking amount from the wallet
+// address from in an HTLC output, see htlcScript. The address to can claim
+// it with the preimage of hash, or from can take it back once the chain is
+// past timeout. It pays fee and returns the rest to from as change.
+func NewHTLCTransaction(from, to string, hash []byte, timeout int64, amount, fee int, UTXOSet *UTXOSet) *Transaction {
 	wallets, err := NewWallets()
 	if err != nil {
 		log.Panic(err)
 	}
 	wallet := wallets.GetWallet(from)
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	acc, validOutputs := bc.FindSpendableOutputs(pubKeyHash, amount)
 
-	if acc < amount {
+	toScript, err := AddressScript(to)
+	if err != nil {
+		log.Panic(err)
+	}
+	recipient, ok := extractPubKeyHash(toScript)
+	if !ok {
+		log.Panic("ERROR: Recipient address is not a wallet address")
+	}
+
+	payment := TXOutput{amount, htlcScript(hash, recipient, HashPubKey(wallet.PublicKey), timeout)}
+	return newTransferTransaction(&wallet, from, payment, fee, 0, UTXOSet)
+}
+
+// NewHTLCSpendTransaction creates a transaction sending the value of HTLC
+// output vout of prevTx, less fee, to the address to. The recipient of the
+// contract claims it by giving preimage; the sender refunds it with a nil
+// preimage, which sets LockTime to the contract's timeout. An output paying
+// to a script hash needs the HTLC script as redeemScript.
+func NewHTLCSpendTransaction(wallet *Wallet, prevTx *Transaction, vout int, redeemScript, preimage []byte, to string, fee int) *Transaction {
+	out := prevTx.Vout[vout]
+	script := out.ScriptPubKey
+	if scriptHash, ok := extractScriptHash(script); ok && bytes.Equal(HashPubKey(redeemScript), scriptHash) {
+		script = redeemScript
+	}
+	contract, ok := extractHTLC(script)
+	if !ok {
+		log.Panic("ERROR: Output is not an HTLC")
+	}
+	if out.Value <= fee {
+		log.Panic("ERROR: Fee exceeds the value of the output")
+	}
+
+	var lockTime int64
+	if preimage == nil {
+		lockTime = contract.Timeout
+	}
+	input := TXInput{prevTx.ID, vout, nil, 0}
+	tx := Transaction{nil, txVersion, []TXInput{input}, []TXOutput{*NewTXOutput(out.Value-fee, to)}, lockTime}
+	tx.SignHTLC(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(prevTx.ID): *prevTx}, redeemScript, preimage)
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// NewMultisigTransaction creates a transaction sending amount from the
+// multisig address from to another address, paying fee and returning the
+// rest to from. Its inputs are left unsigned; the owners of the keys add
+// their signatures with SignMultisig. When from is a script hash address,
+// redeemScript is the multisig script it hashes, which the inputs carry
+// for the signers.
+func NewMultisigTransaction(from, to string, redeemScript []byte, amount, fee int, UTXOSet *UTXOSet) *Transaction {
+	tx := newUnsignedTransaction(from, *NewTXOutput(amount, to), fee, 0, UTXOSet)
+	if redeemScript != nil {
+		for i := range tx.Vin {
+			tx.Vin[i].ScriptSig = appendPushData(nil, redeemScript)
+		}
+		tx.ID = tx.Hash()
+	}
+	return tx
+}
+
+// newUnsignedTransaction selects outputs of from to make payment and fee,
+// and returns the rest to from as change
+func newUnsignedTransaction(from string, payment TXOutput, fee int, lockTime int64, UTXOSet *UTXOSet) *Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	amount := payment.Value
+
+	lockingScript, err := AddressScript(from)
+	if err != nil {
+		log.Panic(err)
+	}
+	acc, validOutputs := UTXOSet.FindSpendableOutputs(lockingScript, amount+fee)
+
+	if acc < amount+fee {
 		log.Panic("Error: not enough funds.")
 	}
 
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, wallet.PublicKey}
+			input := TXInput{txID, out, nil, 0}
 			inputs = append(inputs, input)
 		}
 		if err != nil {
@@ -86,135 +269,399 @@ func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) *Transactio
 		}
 	}
 
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	outputs = append(outputs, payment)
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from))
 	}
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{nil, txVersion, inputs, outputs, lockTime}
 	tx.ID = tx.Hash()
-	bc.SignTransaction(&tx, wallet.PrivateKey)
 
 	return &tx
 }
 
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
+	tx.ID = tx.Hash()
+}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+// Lock makes address the only one able to spend out, with the script
+// AddressScript returns for it
+func (out *TXOutput) Lock(address []byte) {
+	script, err := AddressScript(string(address))
 	if err != nil {
 		log.Panic(err)
 	}
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+	out.ScriptPubKey = script
 }
 
-func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+// IsLockedWith tells if out is locked by lockingScript
+func (out *TXOutput) IsLockedWith(lockingScript []byte) bool {
+	return bytes.Equal(out.ScriptPubKey, lockingScript)
 }
 
-func (out *TXOutput) Lock(address []byte) {
-	pubKeyHash := Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+// Sign unlocks every input of tx, which have to spend pay-to-pubkey-hash
+// outputs of the key pair of privKey
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+	if tx.IsCoinbase() {
+		return
+	}
+	pubKey := publicKeyBytes(&privKey.PublicKey)
+	for inID, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		signature := tx.signInput(inID, prevTx.Vout[vin.Vout].ScriptPubKey, privKey)
+		tx.Vin[inID].ScriptSig = payToPubKeyHashScriptSig(signature, pubKey)
+	}
 }
 
-func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+// SignHTLC unlocks every input of tx, which have to spend HTLC outputs
+// privKey is the recipient or the sender of. The recipient gives preimage,
+// the sender nil. Inputs spending a script hash output also push
+// redeemScript.
+func (tx *Transaction) SignHTLC(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, redeemScript, preimage []byte) {
+	pubKey := publicKeyBytes(&privKey.PublicKey)
+	for inID, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		scriptPubKey := prevTx.Vout[vin.Vout].ScriptPubKey
+		signature := tx.signInput(inID, scriptPubKey, privKey)
+		tx.Vin[inID].ScriptSig = htlcScriptSig(signature, pubKey, preimage)
+		if _, ok := extractScriptHash(scriptPubKey); ok {
+			tx.Vin[inID].ScriptSig = appendPushData(tx.Vin[inID].ScriptSig, redeemScript)
+		}
+	}
 }
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
-	if tx.IsCoinbase() {
-		return
+// SignMultisig adds the signature of privKey to every input of tx that
+// spends a multisig output privKey holds one of the keys of, and returns
+// how many inputs it signed. The signatures already there are kept, in the
+// order of their keys, up to the number the output requires.
+func (tx *Transaction) SignMultisig(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) int {
+	pubKey := publicKeyBytes(&privKey.PublicKey)
+	signed := 0
+	for inID, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		scriptPubKey := prevTx.Vout[vin.Vout].ScriptPubKey
+		redeemScript, existing, ok := multisigSpend(vin, scriptPubKey)
+		if !ok {
+			continue
+		}
+		m, pubKeys, _ := extractMultisig(redeemScript)
+
+		hash := tx.signatureHash(inID, scriptPubKey)
+		signatures := make([][]byte, len(pubKeys))
+		for _, signature := range existing {
+			for k, key := range pubKeys {
+				if signatures[k] == nil && verifySignature(key, signature, hash) {
+					signatures[k] = signature
+					break
+				}
+			}
+		}
+		for k, key := range pubKeys {
+			if signatures[k] == nil && bytes.Equal(key, pubKey) {
+				signatures[k] = tx.signInput(inID, scriptPubKey, privKey)
+				signed++
+				break
+			}
+		}
+
+		var ordered [][]byte
+		for _, signature := range signatures {
+			if signature != nil && len(ordered) < m {
+				ordered = append(ordered, signature)
+			}
+		}
+		tx.Vin[inID].ScriptSig = multisigScriptSig(ordered)
+		if !bytes.Equal(redeemScript, scriptPubKey) {
+			tx.Vin[inID].ScriptSig = appendPushData(tx.Vin[inID].ScriptSig, redeemScript)
+		}
 	}
+	tx.ID = tx.Hash()
+	return signed
+}
+
+// multisigSpend returns the multisig script input vin has to meet and the
+// signatures its ScriptSig holds so far. The script is scriptPubKey itself,
+// or for a script hash output the redeem script ScriptSig pushes last.
+func multisigSpend(vin TXInput, scriptPubKey []byte) ([]byte, [][]byte, bool) {
+	redeemScript := scriptPubKey
+	signatures, _ := pushedData(vin.ScriptSig)
+	if scriptHash, ok := extractScriptHash(scriptPubKey); ok {
+		if len(signatures) == 0 || !bytes.Equal(HashPubKey(signatures[len(signatures)-1]), scriptHash) {
+			return nil, nil, false
+		}
+		redeemScript = signatures[len(signatures)-1]
+		signatures = signatures[:len(signatures)-1]
+	}
+
+	if _, _, ok := extractMultisig(redeemScript); !ok {
+		return nil, nil, false
+	}
+	return redeemScript, signatures, true
+}
+
+// signInput returns the signature of privKey over input inID, which spends
+// an output locked by subscript
+func (tx *Transaction) signInput(inID int, subscript []byte, privKey ecdsa.PrivateKey) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, tx.signatureHash(inID, subscript))
+	if err != nil {
+		log.Panic(err)
+	}
+	return append(paddedBytes(r, keyCoordinateSize), paddedBytes(s, keyCoordinateSize)...)
+}
+
+// signatureHash is what the signatures of input inID commit to: the hash of
+// tx with every ScriptSig emptied but the one of input inID, which is
+// replaced by subscript. Before scriptTxVersion that input carried the
+// public key hash of the spent output instead.
+func (tx *Transaction) signatureHash(inID int, subscript []byte) []byte {
 	txCopy := tx.TrimmedCopy()
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Vin[inID].PubKey = nil
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		if err != nil {
-			log.Panic(err)
+	if tx.Version < scriptTxVersion {
+		pubKeyHash, _ := extractPubKeyHash(subscript)
+		txCopy.Vin[inID].ScriptSig = payToPubKeyHashScriptSig(nil, pubKeyHash)
+	} else {
+		txCopy.Vin[inID].ScriptSig = subscript
+	}
+	return txCopy.Hash()
+}
+
+// String returns a human-readable representation of a transaction
+func (tx Transaction) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	lines = append(lines, fmt.Sprintf("     Version:  %d", tx.Version))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
+	}
+
+	for i, input := range tx.Vin {
+		lines = append(lines, fmt.Sprintf("     Input %d:", i))
+		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
+		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		lines = append(lines, fmt.Sprintf("       ScriptSig: %s", disassembleScript(input.ScriptSig)))
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %x", input.Sequence))
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-		tx.Vin[inID].Signature = signature
 	}
+
+	for i, output := range tx.Vout {
+		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		lines = append(lines, fmt.Sprintf("       Value:      %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script:     %s", disassembleScript(output.ScriptPubKey)))
+	}
+
+	return strings.Join(lines, "\n")
 }
 
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, vin.Sequence})
 	}
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.ScriptPubKey})
 	}
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, tx.Version, inputs, outputs, tx.LockTime}
 	return txCopy
 }
 
+// Hash computes the ID of the transaction
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
+	hash := sha256.Sum256(tx.Serialize())
+	return hash[:]
+}
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
+// Size is the length of the serialized transaction, which fee rates are
+// counted against
+func (tx Transaction) Size() int {
+	return len(tx.Serialize())
+}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+// legacyInput returns the signature and public key pushed by the ScriptSig
+// of an input of a transaction older than scriptTxVersion
+func legacyInput(vin TXInput) ([]byte, []byte, bool) {
+	if len(vin.ScriptSig) == 0 {
+		return nil, nil, true
+	}
+	data, ok := pushedData(vin.ScriptSig)
+	if !ok || len(data) != 2 || !bytes.Equal(vin.ScriptSig, payToPubKeyHashScriptSig(data[0], data[1])) {
+		return nil, nil, false
+	}
+	return data[0], data[1], true
+}
 
-	return hash[:]
+// hasLegacyForm tells if the scripts of tx fit the encoding of its version.
+// Transactions older than scriptTxVersion can only have pay-to-pubkey-hash
+// scripts, or two of them could share an encoding and so an ID.
+func (tx *Transaction) hasLegacyForm() bool {
+	if tx.Version >= scriptTxVersion {
+		return true
+	}
+	for _, vin := range tx.Vin {
+		if _, _, ok := legacyInput(vin); !ok || len(vin.ScriptSig) == 0 {
+			return false
+		}
+	}
+	for _, vout := range tx.Vout {
+		if _, ok := extractPubKeyHash(vout.ScriptPubKey); !ok {
+			return false
+		}
+	}
+	return true
 }
 
+// Serialize returns the binary encoding of the transaction, which leaves
+// out the ID
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	return tx.appendTo(nil)
+}
+
+func (tx *Transaction) appendTo(buf []byte) []byte {
+	buf = appendUint32(buf, uint32(tx.Version))
+
+	buf = appendVarint(buf, uint64(len(tx.Vin)))
+	for _, vin := range tx.Vin {
+		buf = appendBytes(buf, vin.Txid)
+		buf = appendUint32(buf, uint32(vin.Vout))
+		if tx.Version < scriptTxVersion {
+			signature, pubKey, _ := legacyInput(vin)
+			buf = appendBytes(buf, signature)
+			buf = appendBytes(buf, pubKey)
+		} else {
+			buf = appendBytes(buf, vin.ScriptSig)
+		}
+		buf = appendUint32(buf, vin.Sequence)
+	}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+	buf = appendVarint(buf, uint64(len(tx.Vout)))
+	for _, vout := range tx.Vout {
+		buf = appendUint64(buf, uint64(vout.Value))
+		if tx.Version < scriptTxVersion {
+			pubKeyHash, _ := extractPubKeyHash(vout.ScriptPubKey)
+			buf = appendBytes(buf, pubKeyHash)
+		} else {
+			buf = appendBytes(buf, vout.ScriptPubKey)
+		}
+	}
+
+	return appendUint64(buf, uint64(tx.LockTime))
+}
+
+// DeserializeTransaction decodes a transaction from its binary encoding
+// and computes its ID
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := decoder{data: data}
+	tx := readTransaction(&d)
+	err := d.finish()
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return encoded.Bytes()
+	tx.ID = tx.Hash()
+	return tx, nil
 }
 
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+func readTransaction(d *decoder) *Transaction {
+	tx := &Transaction{Version: int(d.uint32())}
+
+	for i := d.count(); i > 0 && d.err == nil; i-- {
+		var vin TXInput
+		vin.Txid = d.bytes()
+		vin.Vout = int(int32(d.uint32()))
+		if tx.Version < scriptTxVersion {
+			signature := d.bytes()
+			vin.ScriptSig = payToPubKeyHashScriptSig(signature, d.bytes())
+		} else {
+			vin.ScriptSig = d.bytes()
+		}
+		vin.Sequence = d.uint32()
+		tx.Vin = append(tx.Vin, vin)
+	}
 
+	for i := d.count(); i > 0 && d.err == nil; i-- {
+		var vout TXOutput
+		vout.Value = int(int64(d.uint64()))
+		if tx.Version < scriptTxVersion {
+			vout.ScriptPubKey = payToPubKeyHashScript(d.bytes())
+		} else {
+			vout.ScriptPubKey = d.bytes()
+		}
+		tx.Vout = append(tx.Vout, vout)
+	}
+
+	tx.LockTime = int64(d.uint64())
+	return tx
+}
+
+// Verify tells if every input of tx unlocks the output it spends. prevTXs
+// holds the spent transactions keyed by hex encoded ID.
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
 	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Vin[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:keyLen/2])
-		y.SetBytes(vin.PubKey[keyLen/2:])
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+		if tx.verifyInput(inID, prevTx.Vout[vin.Vout].ScriptPubKey) != nil {
 			return false
 		}
-
 	}
 	return true
 }
 
+// verifyInput runs the ScriptSig of input inID against scriptPubKey, the
+// locking script of the output it spends
+func (tx *Transaction) verifyInput(inID int, scriptPubKey []byte) error {
+	return executeScript(tx.Vin[inID].ScriptSig, scriptPubKey, inputChecker{tx, inID, scriptPubKey})
+}
+
+// inputChecker checks the scripts of input inID of tx, which spends an
+// output locked by scriptPubKey
+type inputChecker struct {
+	tx           *Transaction
+	inID         int
+	scriptPubKey []byte
+}
+
+func (c inputChecker) checkSig(signature, pubKey []byte) bool {
+	return verifySignature(pubKey, signature, c.tx.signatureHash(c.inID, c.scriptPubKey))
+}
+
+// checkLockTime tells if the LockTime of the transaction has reached
+// lockTime, both being heights or both Unix times. The input must not be
+// final, or LockTime would not be enforced.
+func (c inputChecker) checkLockTime(lockTime int64) bool {
+	if (lockTime < lockTimeThreshold) != (c.tx.LockTime < lockTimeThreshold) {
+		return false
+	}
+	return lockTime <= c.tx.LockTime && c.tx.Vin[c.inID].Sequence != sequenceFinal
+}
+
 func NewTXOutput(value int, address string) *TXOutput {
 	txo := &TXOutput{value, nil}
 	txo.Lock([]byte(address))
 	return txo
 }
+
+func (outs TXOutputs) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(outs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+func DeserializeOutputs(data []byte) TXOutputs {
+	var outputs TXOutputs
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return outputs
+}
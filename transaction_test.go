@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestTransactionFee(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+
+	cases := []struct {
+		name    string
+		amount  int
+		fee     int
+		outputs int
+	}{
+		{"no fee", 7, 0, 2},
+		{"fee", 7, 3, 2},
+		{"fee takes the change", 7, 43, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := newTestChain(alice)
+			genesis, err := bc.GetBlock(bc.tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx := testTransfer(bc, alice, bob, c.amount, c.fee, 0)
+			if len(tx.Vout) != c.outputs {
+				t.Errorf("transaction has %d outputs, want %d", len(tx.Vout), c.outputs)
+			}
+			if fee, err := bc.TransactionFee(tx); err != nil || fee != c.fee {
+				t.Errorf("fee before mining is %d (%v), want %d", fee, err, c.fee)
+			}
+
+			// the fee is still known once the spent outputs left the UTXO set
+			err = bc.AddBlock(newTestBlock(genesis, miner, tx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fee, err := bc.TransactionFee(tx); err != nil || fee != c.fee {
+				t.Errorf("fee after mining is %d (%v), want %d", fee, err, c.fee)
+			}
+			if got := testBalance(bc, alice); got != params.Subsidy-c.amount-c.fee {
+				t.Errorf("alice has %d, want %d", got, params.Subsidy-c.amount-c.fee)
+			}
+		})
+	}
+}
+
+func TestCoinbaseHeight(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	spend := testSpend(NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy), alice, bob, 8)
+	short := NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy)
+	short.Vin[0].ScriptSig = appendPushData(nil, IntToHex(1)[:7])
+	split := NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy)
+	split.Vin[0].ScriptSig = appendPushData(appendPushData(nil, IntToHex(1)[:4]), IntToHex(1)[4:])
+	long := NewCoinbaseTX(string(alice.GetAddress()), "", 1, params.Subsidy)
+	long.Vin[0].ScriptSig = appendPushData(nil, append(IntToHex(1), "data"...))
+
+	cases := []struct {
+		name   string
+		tx     *Transaction
+		height int
+		ok     bool
+	}{
+		{"genesis", NewCoinbaseTX(string(alice.GetAddress()), params.GenesisCoinbaseData, 0, params.Subsidy), 0, true},
+		{"with data", NewCoinbaseTX(string(alice.GetAddress()), "data", 300, params.Subsidy), 300, true},
+		{"short data", short, 0, false},
+		{"height split across pushes", split, 0, false},
+		{"height push with data", long, 0, false},
+		{"not a coinbase", spend, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			height, ok := c.tx.CoinbaseHeight()
+			if height != c.height || ok != c.ok {
+				t.Errorf("got height %d, %v, want %d, %v", height, ok, c.height, c.ok)
+			}
+		})
+	}
+}
+
+func TestMultisigTransaction(t *testing.T) {
+	alice, bob, carol, dave, miner := NewWallet(), NewWallet(), NewWallet(), NewWallet(), NewWallet()
+	multisig := multisigScript(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey})
+
+	cases := []struct {
+		name          string
+		address       []byte
+		lockingScript []byte
+		redeemScript  []byte
+	}{
+		{"multisig", MultisigAddress(2, [][]byte{alice.PublicKey, bob.PublicKey, carol.PublicKey}), multisig, nil},
+		{"script hash", ScriptHashAddress(multisig), scriptHashScript(HashPubKey(multisig)), multisig},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := newTestChain(alice)
+			genesis, err := bc.GetBlock(bc.tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fund := newTransferTransaction(alice, string(alice.GetAddress()), *NewTXOutput(20, string(c.address)), 0, 0, &UTXOSet{bc})
+			b1 := newTestBlock(genesis, miner, fund)
+			err = bc.AddBlock(b1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tx := NewMultisigTransaction(string(c.address), string(dave.GetAddress()), c.redeemScript, 8, 0, &UTXOSet{bc})
+			if signed := bc.SignMultisigTransaction(tx, carol.PrivateKey); signed != 1 {
+				t.Errorf("carol signed %d inputs, want 1", signed)
+			}
+			if err := bc.CheckTransaction(tx); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("one signature: got %v, want %v", err, ErrBadSignature)
+			}
+			if signed := bc.SignMultisigTransaction(tx, dave.PrivateKey); signed != 0 {
+				t.Errorf("dave signed %d inputs, want 0", signed)
+			}
+
+			// alice's key comes first in the script, so her signature is
+			// put before carol's
+			if signed := bc.SignMultisigTransaction(tx, alice.PrivateKey); signed != 1 {
+				t.Errorf("alice signed %d inputs, want 1", signed)
+			}
+			if err := bc.CheckTransaction(tx); err != nil {
+				t.Fatalf("two signatures: %v", err)
+			}
+
+			err = bc.AddBlock(newTestBlock(b1, miner, tx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testBalance(bc, dave); got != 8 {
+				t.Errorf("dave has %d, want 8", got)
+			}
+			if got, _ := (UTXOSet{bc}).GetBalance(c.lockingScript); got != 12 {
+				t.Errorf("multisig address has %d, want 12", got)
+			}
+		})
+	}
+}
+
+func TestHTLCTransaction(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+
+	// alice locks 20 to bob until height 2
+	preimage := []byte("0123456789abcdef0123456789abcdef")
+	hash := sha256.Sum256(preimage)
+	htlc := htlcScript(hash[:], HashPubKey(bob.PublicKey), HashPubKey(alice.PublicKey), 2)
+
+	cases := []struct {
+		name          string
+		lockingScript []byte
+		redeemScript  []byte
+	}{
+		{"htlc", htlc, nil},
+		{"script hash", scriptHashScript(HashPubKey(htlc)), htlc},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := newTestChain(alice)
+			genesis, err := bc.GetBlock(bc.tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fund := newTransferTransaction(alice, string(alice.GetAddress()), TXOutput{20, c.lockingScript}, 0, 0, &UTXOSet{bc})
+			b1 := newTestBlock(genesis, miner, fund)
+			err = bc.AddBlock(b1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claim := NewHTLCSpendTransaction(bob, fund, 0, c.redeemScript, preimage, string(bob.GetAddress()), 1)
+			if err := bc.CheckTransaction(claim); err != nil {
+				t.Errorf("claim: %v", err)
+			}
+			wrong := NewHTLCSpendTransaction(bob, fund, 0, c.redeemScript, []byte("0123456789abcdef0123456789abcdeX"), string(bob.GetAddress()), 1)
+			if err := bc.CheckTransaction(wrong); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("wrong preimage: got %v, want %v", err, ErrBadSignature)
+			}
+
+			// the refund is locked until the block after the timeout
+			refund := NewHTLCSpendTransaction(alice, fund, 0, c.redeemScript, nil, string(alice.GetAddress()), 1)
+			if refund.LockTime != 2 {
+				t.Errorf("refund lock time is %d, want 2", refund.LockTime)
+			}
+			if err := bc.CheckTransaction(refund); !errors.Is(err, ErrNonFinalTx) {
+				t.Errorf("early refund: got %v, want %v", err, ErrNonFinalTx)
+			}
+			b2 := newTestBlock(b1, miner)
+			err = bc.AddBlock(b2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = bc.AddBlock(newTestBlock(b2, miner, refund))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testBalance(bc, alice); got != params.Subsidy-1 {
+				t.Errorf("alice has %d, want %d", got, params.Subsidy-1)
+			}
+		})
+	}
+}
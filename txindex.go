@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+)
+
+const txIndexBucket = "txindex"
+
+// TxLocation tells where a transaction is stored on the chain
+type TxLocation struct {
+	BlockHash []byte
+	Height    int
+	Index     int
+}
+
+func (loc TxLocation) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(loc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+func DeserializeTxLocation(data []byte) TxLocation {
+	var loc TxLocation
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&loc)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return loc
+}
+
+// indexTransactions records the location of every transaction of block.
+// It runs inside the caller's store transaction.
+func indexTransactions(tx StoreTx, block *Block) error {
+	for i, t := range block.Transactions {
+		loc := TxLocation{block.Hash, block.Height, i}
+		err := tx.Put(txIndexBucket, t.ID, loc.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexTransactions removes the transactions of block from the index
+func unindexTransactions(tx StoreTx, block *Block) error {
+	for _, t := range block.Transactions {
+		err := tx.Delete(txIndexBucket, t.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findTransaction reads an indexed transaction inside the caller's store
+// transaction
+func findTransaction(tx StoreTx, ID []byte) (*Transaction, TxLocation, error) {
+	locData := tx.Get(txIndexBucket, ID)
+	if locData == nil {
+		return nil, TxLocation{}, errors.New("Transaction is not found")
+	}
+	loc := DeserializeTxLocation(locData)
+
+	block, err := getBlock(tx, loc.BlockHash)
+	if err != nil {
+		return nil, TxLocation{}, err
+	}
+	if loc.Index >= len(block.Transactions) {
+		return nil, TxLocation{}, errors.New("Transaction is not found")
+	}
+
+	return block.Transactions[loc.Index], loc, nil
+}
+
+// ReindexTransactions drops the transaction index and rebuilds it from the
+// whole chain
+func (bc *Blockchain) ReindexTransactions() int {
+	counter := 0
+
+	err := bc.store.Batch(func(tx StoreTx) error {
+		err := tx.DropBucket(txIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		return walkChain(tx, func(block *Block) error {
+			counter += len(block.Transactions)
+			return indexTransactions(tx, block)
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return counter
+}
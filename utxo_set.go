@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+)
+
+// utxoBucket replaced the chainstate bucket when entries started to record
+// their height and coinbase flag, so older databases get the set rebuilt
+const utxoBucket = "utxo"
+const legacyUTXOBucket = "chainstate"
+
+var errEnoughFunds = errors.New("Enough funds are found")
+
+// UTXOSet is the persistent set of unspent transaction outputs kept in the
+// utxo bucket, keyed by transaction ID
+type UTXOSet struct {
+	Blockchain *Blockchain
+}
+
+// FindSpendableOutputs selects mature outputs locked by lockingScript until
+// they add up to amount
+func (u UTXOSet) FindSpendableOutputs(lockingScript []byte, amount int) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+	store := u.Blockchain.store
+
+	err := store.View(func(tx StoreTx) error {
+		height, err := nextHeight(tx)
+		if err != nil {
+			return err
+		}
+
+		return tx.ForEach(utxoBucket, func(k, v []byte) error {
+			txID := hex.EncodeToString(k)
+			outs := DeserializeOutputs(v)
+			if !outs.IsMature(height) {
+				return nil
+			}
+
+			for _, outIdx := range outs.Indexes() {
+				out := outs.Outputs[outIdx]
+				if out.IsLockedWith(lockingScript) && accumulated < amount {
+					accumulated += out.Value
+					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+				}
+			}
+			if accumulated >= amount {
+				return errEnoughFunds
+			}
+			return nil
+		})
+	})
+	if err != nil && err != errEnoughFunds {
+		log.Panic(err)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+func (u UTXOSet) FindUTXO(lockingScript []byte) []TXOutput {
+	var UTXOs []TXOutput
+	store := u.Blockchain.store
+
+	err := store.View(func(tx StoreTx) error {
+		return tx.ForEach(utxoBucket, func(k, v []byte) error {
+			outs := DeserializeOutputs(v)
+
+			for _, outIdx := range outs.Indexes() {
+				out := outs.Outputs[outIdx]
+				if out.IsLockedWith(lockingScript) {
+					UTXOs = append(UTXOs, out)
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return UTXOs
+}
+
+// GetBalance returns the value locked by lockingScript that can be spent in
+// the next block and the value that still waits for coinbase maturity
+func (u UTXOSet) GetBalance(lockingScript []byte) (int, int) {
+	spendable, immature := 0, 0
+	store := u.Blockchain.store
+
+	err := store.View(func(tx StoreTx) error {
+		height, err := nextHeight(tx)
+		if err != nil {
+			return err
+		}
+
+		return tx.ForEach(utxoBucket, func(k, v []byte) error {
+			outs := DeserializeOutputs(v)
+
+			for _, out := range outs.Outputs {
+				if !out.IsLockedWith(lockingScript) {
+					continue
+				}
+				if outs.IsMature(height) {
+					spendable += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return spendable, immature
+}
+
+func (u UTXOSet) CountTransactions() int {
+	store := u.Blockchain.store
+	counter := 0
+
+	err := store.View(func(tx StoreTx) error {
+		return tx.ForEach(utxoBucket, func(k, v []byte) error {
+			counter++
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return counter
+}
+
+// Reindex drops the utxo bucket, and the legacy chainstate bucket, and
+// rebuilds the set from the whole chain
+func (u UTXOSet) Reindex() {
+	store := u.Blockchain.store
+
+	UTXO := u.Blockchain.FindUTXO()
+
+	err := store.Batch(func(tx StoreTx) error {
+		err := tx.DropBucket(legacyUTXOBucket)
+		if err != nil {
+			return err
+		}
+		err = tx.DropBucket(utxoBucket)
+		if err != nil {
+			return err
+		}
+
+		for txID, outs := range UTXO {
+			key, err := hex.DecodeString(txID)
+			if err != nil {
+				return err
+			}
+			err = tx.Put(utxoBucket, key, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// updateUTXO removes the outputs spent by block and adds the ones it creates.
+// It runs inside the caller's store transaction so the block and the set
+// are written atomically.
+func updateUTXO(tx StoreTx, block *Block) error {
+	var err error
+
+	for _, t := range block.Transactions {
+		if t.IsCoinbase() == false {
+			for _, vin := range t.Vin {
+				outsBytes := tx.Get(utxoBucket, vin.Txid)
+				if outsBytes == nil {
+					continue
+				}
+				outs := DeserializeOutputs(outsBytes)
+				delete(outs.Outputs, vin.Vout)
+
+				if len(outs.Outputs) == 0 {
+					err = tx.Delete(utxoBucket, vin.Txid)
+				} else {
+					err = tx.Put(utxoBucket, vin.Txid, outs.Serialize())
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		newOutputs := TXOutputs{make(map[int]TXOutput), block.Height, t.IsCoinbase()}
+		for outIdx, out := range t.Vout {
+			newOutputs.Outputs[outIdx] = out
+		}
+		err = tx.Put(utxoBucket, t.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoUTXO reverts updateUTXO for block: the outputs it created are removed
+// and the ones it spent are read back from the transaction index
+func undoUTXO(tx StoreTx, block *Block) error {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		t := block.Transactions[i]
+
+		err := tx.Delete(utxoBucket, t.ID)
+		if err != nil {
+			return err
+		}
+		if t.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range t.Vin {
+			prevTx, loc, err := findTransaction(tx, vin.Txid)
+			if err != nil {
+				return err
+			}
+
+			outs := TXOutputs{make(map[int]TXOutput), loc.Height, prevTx.IsCoinbase()}
+			if outsBytes := tx.Get(utxoBucket, vin.Txid); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
+			}
+			outs.Outputs[vin.Vout] = prevTx.Vout[vin.Vout]
+
+			err = tx.Put(utxoBucket, vin.Txid, outs.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextHeight is the height of the block that would extend the tip
+func nextHeight(tx StoreTx) (int, error) {
+	tip := getTip(tx)
+	if len(tip) == 0 {
+		return 0, nil
+	}
+
+	header, err := getHeader(tx, tip)
+	if err != nil {
+		return 0, err
+	}
+	return header.Height + 1, nil
+}
+
+// Indexes returns the output indexes of outs in ascending order
+func (outs TXOutputs) Indexes() []int {
+	var indexes []int
+	for outIdx := range outs.Outputs {
+		indexes = append(indexes, outIdx)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
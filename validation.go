@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Rule violations reported by AddBlock and CheckTransaction. The
+// returned errors wrap one of these, so callers can tell them apart with
+// errors.Is.
+var (
+	ErrBadVersion        = errors.New("Block version is not supported")
+	ErrBadProofOfWork    = errors.New("Block hash does not satisfy the proof of work")
+	ErrBadPrevBlock      = errors.New("Block does not link to a known parent")
+	ErrBadHeight         = errors.New("Block height is not the parent height plus one")
+	ErrBadDifficulty     = errors.New("Block bits do not match the expected difficulty")
+	ErrTimeTooOld        = errors.New("Block timestamp is not after the median time past")
+	ErrTimeTooNew        = errors.New("Block timestamp is too far in the future")
+	ErrBadMerkleRoot     = errors.New("Block Merkle root does not match its transactions")
+	ErrBlockTooLarge     = errors.New("Block exceeds the maximum block size")
+	ErrNoCoinbase        = errors.New("First transaction of the block is not a coinbase")
+	ErrMultipleCoinbase  = errors.New("Block has more than one coinbase")
+	ErrBadCoinbaseHeight = errors.New("Coinbase does not commit to the block height")
+	ErrBadCoinbaseValue  = errors.New("Coinbase pays more than the block subsidy and fees")
+	ErrTxTooLarge        = errors.New("Transaction exceeds the maximum transaction size")
+	ErrBadTxID           = errors.New("Transaction ID does not match its contents")
+	ErrDuplicateTx       = errors.New("Transaction ID is already in use")
+	ErrBadOutputValue    = errors.New("Transaction output value is out of range")
+	ErrValueOutOfRange   = errors.New("Transaction values add up to more than the money supply")
+	ErrMissingInput      = errors.New("Input references an unknown or spent output")
+	ErrDuplicateInput    = errors.New("Transaction spends the same output twice")
+	ErrDoubleSpend       = errors.New("Output is spent twice in the block")
+	ErrImmatureCoinbase  = errors.New("Coinbase output is spent before it matured")
+	ErrValueNotConserved = errors.New("Transaction outputs exceed its inputs")
+	ErrNonFinalTx        = errors.New("Transaction lock time has not passed")
+	ErrSequenceLocked    = errors.New("Input is spent before its relative lock time")
+	ErrBadSignature      = errors.New("Input script does not unlock the spent output")
+)
+
+// checkBlockSanity runs the checks that need nothing but the block itself
+func checkBlockSanity(block *Block) error {
+	if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+		return fmt.Errorf("%w: block %x", ErrBadProofOfWork, block.Hash)
+	}
+
+	size := block.Size()
+	if size > maxBlockSize {
+		return fmt.Errorf("%w: block %x has %d bytes", ErrBlockTooLarge, block.Hash, size)
+	}
+
+	// the Merkle root only commits to the IDs, and a tree with an odd level
+	// has the same root when its last transactions are repeated, so the IDs
+	// have to match the transactions and be distinct
+	seen := make(map[string]bool)
+	for _, t := range block.Transactions {
+		if !t.hasLegacyForm() || !bytes.Equal(t.ID, t.Hash()) {
+			return fmt.Errorf("%w: transaction %x", ErrBadTxID, t.ID)
+		}
+		if seen[string(t.ID)] {
+			return fmt.Errorf("%w: transaction %x is repeated in block %x", ErrDuplicateTx, t.ID, block.Hash)
+		}
+		seen[string(t.ID)] = true
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransaction()) {
+		return fmt.Errorf("%w: block %x", ErrBadMerkleRoot, block.Hash)
+	}
+	return nil
+}
+
+// checkHeader checks header against its parent, which may be on any
+// branch. The transactions can only be checked once the block is about to
+// be connected.
+func checkHeader(tx StoreTx, header *BlockHeader) error {
+	hash := header.Hash()
+
+	if header.Version < 0 || header.Version > blockVersion {
+		return fmt.Errorf("%w: block %x has version %d", ErrBadVersion, hash, header.Version)
+	}
+	if header.Bits < minTargetBits || header.Bits > maxTargetBits {
+		return fmt.Errorf("%w: block %x has %d bits", ErrBadDifficulty, hash, header.Bits)
+	}
+
+	pow := NewProofOfWork(header)
+	if !pow.Validate() {
+		return fmt.Errorf("%w: block %x", ErrBadProofOfWork, hash)
+	}
+
+	maxTime := timeNow().Unix() + maxFutureBlockTime
+	if header.Timestamp > maxTime {
+		return fmt.Errorf("%w: block %x at %d, latest allowed is %d", ErrTimeTooNew, hash, header.Timestamp, maxTime)
+	}
+
+	if len(header.PrevBlockHash) == 0 {
+		if len(getTip(tx)) != 0 {
+			return fmt.Errorf("%w: block %x has no parent", ErrBadPrevBlock, hash)
+		}
+		if header.Height != 0 {
+			return fmt.Errorf("%w: genesis block at height %d", ErrBadHeight, header.Height)
+		}
+		if header.Bits != params.GenesisBits {
+			return fmt.Errorf("%w: genesis block has %d bits", ErrBadDifficulty, header.Bits)
+		}
+		return nil
+	}
+
+	parent, err := getHeader(tx, header.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("%w: parent %x is not stored", ErrBadPrevBlock, header.PrevBlockHash)
+	}
+	if header.Height != parent.Height+1 {
+		return fmt.Errorf("%w: block at height %d on parent at height %d", ErrBadHeight, header.Height, parent.Height)
+	}
+	if header.Version < parent.Version {
+		return fmt.Errorf("%w: block %x has version %d on parent with version %d", ErrBadVersion, hash, header.Version, parent.Version)
+	}
+
+	mtp, err := medianTimePast(tx, parent)
+	if err != nil {
+		return err
+	}
+	if header.Timestamp <= mtp {
+		return fmt.Errorf("%w: block %x at %d, median time past is %d", ErrTimeTooOld, hash, header.Timestamp, mtp)
+	}
+
+	bits, err := nextBits(tx, parent)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return fmt.Errorf("%w: block has %d bits, expected %d", ErrBadDifficulty, header.Bits, bits)
+	}
+	return nil
+}
+
+func checkBlockTransactions(tx StoreTx, block *Block) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return fmt.Errorf("%w: block %x", ErrNoCoinbase, block.Hash)
+	}
+	if block.Version >= coinbaseHeightVersion {
+		height, ok := block.Transactions[0].CoinbaseHeight()
+		if !ok || height != block.Height {
+			return fmt.Errorf("%w: block %x at height %d", ErrBadCoinbaseHeight, block.Hash, block.Height)
+		}
+	}
+
+	// time locks are measured against the median time past of the parent
+	var mtp int64
+	if len(block.PrevBlockHash) > 0 {
+		parent, err := getHeader(tx, block.PrevBlockHash)
+		if err != nil {
+			return fmt.Errorf("%w: parent %x is not stored", ErrBadPrevBlock, block.PrevBlockHash)
+		}
+		mtp, err = medianTimePast(tx, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	view := newUTXOView(tx, block.Height, mtp)
+	fees := 0
+	coinbaseValue := 0
+
+	for i, t := range block.Transactions {
+		if view.hasTransaction(t.ID) {
+			return fmt.Errorf("%w: transaction %x", ErrDuplicateTx, t.ID)
+		}
+
+		if i == 0 {
+			if !view.isFinal(t) {
+				return fmt.Errorf("%w: coinbase %x is locked until %d", ErrNonFinalTx, t.ID, t.LockTime)
+			}
+			value, err := checkOutputValues(t)
+			if err != nil {
+				return err
+			}
+			coinbaseValue = value
+			view.add(t)
+			continue
+		}
+		if t.IsCoinbase() {
+			return fmt.Errorf("%w: transaction %x at position %d", ErrMultipleCoinbase, t.ID, i)
+		}
+
+		fee, err := checkTransactionInputs(view, t)
+		if err != nil {
+			return err
+		}
+		if fee > maxMoney-fees {
+			return fmt.Errorf("%w: fees of block %x", ErrValueOutOfRange, block.Hash)
+		}
+		fees += fee
+		view.spend(t)
+		view.add(t)
+	}
+
+	subsidy := blockSubsidy(block.Height)
+	if fees > maxMoney-subsidy {
+		return fmt.Errorf("%w: reward of block %x", ErrValueOutOfRange, block.Hash)
+	}
+	reward := subsidy + fees
+	if coinbaseValue > reward {
+		return fmt.Errorf("%w: coinbase pays %d of %d", ErrBadCoinbaseValue, coinbaseValue, reward)
+	}
+
+	return nil
+}
+
+// CheckTransaction checks a non-coinbase transaction against the current
+// UTXO set: every input has to spend a distinct existing unspent output,
+// every output has to be positive, no value or sum of values may exceed
+// maxMoney, the inputs have to cover the outputs and the signatures have
+// to be valid
+func (bc *Blockchain) CheckTransaction(t *Transaction) error {
+	return bc.store.View(func(tx StoreTx) error {
+		if t.IsCoinbase() {
+			return fmt.Errorf("%w: transaction %x", ErrMultipleCoinbase, t.ID)
+		}
+		if size := t.Size(); size > maxTxSize {
+			return fmt.Errorf("%w: transaction %x has %d bytes", ErrTxTooLarge, t.ID, size)
+		}
+
+		view, err := newTipView(tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = checkTransactionInputs(view, t)
+		return err
+	})
+}
+
+// checkTransactionInputs runs the contextual checks of a non-coinbase
+// transaction against view and returns its fee
+func checkTransactionInputs(view *utxoView, t *Transaction) (int, error) {
+	if len(t.Vin) == 0 {
+		return 0, fmt.Errorf("%w: transaction %x has no inputs", ErrMissingInput, t.ID)
+	}
+	if !view.isFinal(t) {
+		return 0, fmt.Errorf("%w: transaction %x is locked until %d", ErrNonFinalTx, t.ID, t.LockTime)
+	}
+
+	outputValue, err := checkOutputValues(t)
+	if err != nil {
+		return 0, err
+	}
+
+	var prevOuts []TXOutput
+	usedOutpoints := make(map[string]bool)
+	inputValue := 0
+	for _, vin := range t.Vin {
+		outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+		if usedOutpoints[outpoint] {
+			return 0, fmt.Errorf("%w: %s in transaction %x", ErrDuplicateInput, outpoint, t.ID)
+		}
+		usedOutpoints[outpoint] = true
+
+		if view.isSpent(vin) {
+			return 0, fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+		}
+		prevTx, out, height, err := view.fetch(vin)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s in transaction %x", ErrMissingInput, outpoint, t.ID)
+		}
+		if prevTx.IsCoinbase() && view.height-height < params.CoinbaseMaturity {
+			return 0, fmt.Errorf("%w: %s from height %d spent at height %d", ErrImmatureCoinbase, outpoint, height, view.height)
+		}
+		err = view.checkSequenceLock(vin, height)
+		if err != nil {
+			return 0, err
+		}
+		if out.Value > maxMoney-inputValue {
+			return 0, fmt.Errorf("%w: inputs of transaction %x", ErrValueOutOfRange, t.ID)
+		}
+		prevOuts = append(prevOuts, out)
+		inputValue += out.Value
+	}
+
+	if outputValue > inputValue {
+		return 0, fmt.Errorf("%w: transaction %x spends %d of %d", ErrValueNotConserved, t.ID, outputValue, inputValue)
+	}
+
+	if !t.hasLegacyForm() {
+		return 0, fmt.Errorf("%w: transaction %x", ErrBadTxID, t.ID)
+	}
+	for inID, out := range prevOuts {
+		err = t.verifyInput(inID, out.ScriptPubKey)
+		if err != nil {
+			return 0, fmt.Errorf("%w: input %d of transaction %x: %v", ErrBadSignature, inID, t.ID, err)
+		}
+	}
+
+	return inputValue - outputValue, nil
+}
+
+// checkOutputValues checks that every output of t is positive and that
+// they add up to at most maxMoney, and returns their sum
+func checkOutputValues(t *Transaction) (int, error) {
+	if len(t.Vout) == 0 {
+		return 0, fmt.Errorf("%w: transaction %x has no outputs", ErrBadOutputValue, t.ID)
+	}
+	outputValue := 0
+	for outIdx, out := range t.Vout {
+		if out.Value <= 0 || out.Value > maxMoney {
+			return 0, fmt.Errorf("%w: output %d of transaction %x is %d", ErrBadOutputValue, outIdx, t.ID, out.Value)
+		}
+		if out.Value > maxMoney-outputValue {
+			return 0, fmt.Errorf("%w: outputs of transaction %x", ErrValueOutOfRange, t.ID)
+		}
+		outputValue += out.Value
+	}
+	return outputValue, nil
+}
+
+// utxoView is the UTXO set as seen part way through the block at height:
+// the stored set plus the outputs created and minus the ones spent by the
+// transactions of the block checked so far. mtp is the median time past of
+// the block's parent, which time locks are compared with.
+type utxoView struct {
+	tx      StoreTx
+	height  int
+	mtp     int64
+	created map[string]*Transaction
+	spent   map[string]bool
+}
+
+func newUTXOView(tx StoreTx, height int, mtp int64) *utxoView {
+	return &utxoView{tx, height, mtp, make(map[string]*Transaction), make(map[string]bool)}
+}
+
+// newTipView returns the view of a block that would extend the tip
+func newTipView(tx StoreTx) (*utxoView, error) {
+	header, err := getHeader(tx, getTip(tx))
+	if err != nil {
+		return nil, err
+	}
+
+	mtp, err := medianTimePast(tx, header)
+	if err != nil {
+		return nil, err
+	}
+	return newUTXOView(tx, header.Height+1, mtp), nil
+}
+
+// isFinal tells if the lock time of t has passed for the view's block. Lock
+// times below lockTimeThreshold are block heights, the others Unix times.
+// The lock time is ignored when every input has sequenceFinal.
+func (v *utxoView) isFinal(t *Transaction) bool {
+	if t.LockTime == 0 {
+		return true
+	}
+	if t.LockTime < lockTimeThreshold && t.LockTime < int64(v.height) {
+		return true
+	}
+	if t.LockTime >= lockTimeThreshold && t.LockTime < v.mtp {
+		return true
+	}
+
+	for _, vin := range t.Vin {
+		if vin.Sequence != sequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSequenceLock checks the relative lock of vin, which spends an output
+// created at height. Time locks count from the median time past of the
+// block before the one that created the output.
+func (v *utxoView) checkSequenceLock(vin TXInput, height int) error {
+	if vin.Sequence == sequenceFinal || vin.Sequence&sequenceLockDisable != 0 {
+		return nil
+	}
+	lock := int64(vin.Sequence & sequenceLockMask)
+
+	if vin.Sequence&sequenceLockTypeTime == 0 {
+		if int64(v.height-height) < lock {
+			return fmt.Errorf("%w: %x:%d needs %d blocks, has %d", ErrSequenceLocked, vin.Txid, vin.Vout, lock, v.height-height)
+		}
+		return nil
+	}
+
+	confirmed, err := v.confirmationTime(vin)
+	if err != nil {
+		return err
+	}
+	lock <<= sequenceGranularity
+	if v.mtp-confirmed < lock {
+		return fmt.Errorf("%w: %x:%d needs %d seconds, has %d", ErrSequenceLocked, vin.Txid, vin.Vout, lock, v.mtp-confirmed)
+	}
+	return nil
+}
+
+// confirmationTime returns the median time past of the parent of the block
+// that created the output spent by vin
+func (v *utxoView) confirmationTime(vin TXInput) (int64, error) {
+	if _, ok := v.created[hex.EncodeToString(vin.Txid)]; ok {
+		return v.mtp, nil
+	}
+
+	_, loc, err := findTransaction(v.tx, vin.Txid)
+	if err != nil {
+		return 0, err
+	}
+	header, err := getHeader(v.tx, loc.BlockHash)
+	if err != nil {
+		return 0, err
+	}
+	if len(header.PrevBlockHash) == 0 {
+		return header.Timestamp, nil
+	}
+
+	parent, err := getHeader(v.tx, header.PrevBlockHash)
+	if err != nil {
+		return 0, err
+	}
+	return medianTimePast(v.tx, parent)
+}
+
+func (v *utxoView) hasTransaction(ID []byte) bool {
+	return v.created[hex.EncodeToString(ID)] != nil || v.tx.Get(utxoBucket, ID) != nil
+}
+
+func (v *utxoView) isSpent(vin TXInput) bool {
+	return v.spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)]
+}
+
+func (v *utxoView) add(t *Transaction) {
+	v.created[hex.EncodeToString(t.ID)] = t
+}
+
+func (v *utxoView) spend(t *Transaction) {
+	for _, vin := range t.Vin {
+		v.spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+	}
+}
+
+// fetch resolves the output spent by vin either among the transactions
+// added to the view or in the stored UTXO set, along with the height of
+// the block that created it
+func (v *utxoView) fetch(vin TXInput) (*Transaction, TXOutput, int, error) {
+	if prevTx, ok := v.created[hex.EncodeToString(vin.Txid)]; ok {
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return nil, TXOutput{}, 0, ErrMissingInput
+		}
+		return prevTx, prevTx.Vout[vin.Vout], v.height, nil
+	}
+
+	outsBytes := v.tx.Get(utxoBucket, vin.Txid)
+	if outsBytes == nil {
+		return nil, TXOutput{}, 0, ErrMissingInput
+	}
+	outs := DeserializeOutputs(outsBytes)
+	out, ok := outs.Outputs[vin.Vout]
+	if !ok {
+		return nil, TXOutput{}, 0, ErrMissingInput
+	}
+
+	prevTx, _, err := findTransaction(v.tx, vin.Txid)
+	if err != nil {
+		log.Panic(err)
+	}
+	return prevTx, out, outs.Height, nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAddBlock(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+
+	cases := []struct {
+		name  string
+		block func(genesis *Block, payment *Transaction) *Block
+		err   error
+	}{
+		{"valid", func(genesis *Block, payment *Transaction) *Block {
+			return newTestBlock(genesis, miner, payment)
+		}, nil},
+		{"bad proof of work", func(genesis *Block, payment *Transaction) *Block {
+			block := newTestBlock(genesis, miner, payment)
+			block.Nonce++
+			return block
+		}, ErrBadProofOfWork},
+		{"not on the tip", func(genesis *Block, payment *Transaction) *Block {
+			return newTestBlock(&Block{Hash: []byte("unknown parent")}, miner)
+		}, ErrBadPrevBlock},
+		{"bad height", func(genesis *Block, payment *Transaction) *Block {
+			return NewBlock([]*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", 2, blockSubsidy(2))}, genesis.Hash, 2, params.GenesisBits)
+		}, ErrBadHeight},
+		{"time too old", func(genesis *Block, payment *Transaction) *Block {
+			return newTestBlockAt(genesis, miner, genesis.Timestamp, payment)
+		}, ErrTimeTooOld},
+		{"time too new", func(genesis *Block, payment *Transaction) *Block {
+			return newTestBlockAt(genesis, miner, timeNow().Unix()+maxFutureBlockTime+60, payment)
+		}, ErrTimeTooNew},
+		{"block too large", func(genesis *Block, payment *Transaction) *Block {
+			data := string(make([]byte, maxBlockSize))
+			return NewBlock([]*Transaction{NewCoinbaseTX(string(miner.GetAddress()), data, 1, blockSubsidy(1))}, genesis.Hash, 1, params.GenesisBits)
+		}, ErrBlockTooLarge},
+		{"bad difficulty", func(genesis *Block, payment *Transaction) *Block {
+			return NewBlock([]*Transaction{NewCoinbaseTX(string(miner.GetAddress()), "", 1, blockSubsidy(1))}, genesis.Hash, 1, params.GenesisBits+1)
+		}, ErrBadDifficulty},
+		{"bad version", func(genesis *Block, payment *Transaction) *Block {
+			block := newTestBlock(genesis, miner, payment)
+			block.Version = blockVersion + 1
+			testRemine(block)
+			return block
+		}, ErrBadVersion},
+		{"version below parent", func(genesis *Block, payment *Transaction) *Block {
+			block := newTestBlock(genesis, miner, payment)
+			block.Version = coinbaseHeightVersion - 1
+			testRemine(block)
+			return block
+		}, ErrBadVersion},
+		{"bad Merkle root", func(genesis *Block, payment *Transaction) *Block {
+			block := newTestBlock(genesis, miner, payment)
+			block.Transactions = block.Transactions[:1]
+			return block
+		}, ErrBadMerkleRoot},
+		{"invalid transaction", func(genesis *Block, payment *Transaction) *Block {
+			return newTestBlock(genesis, miner, testSpend(genesis.Transactions[0], alice, bob, 51))
+		}, ErrValueNotConserved},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			bc := newTestChain(alice)
+			genesis, err := bc.GetBlock(bc.tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			block := c.block(genesis, testPayment(bc, alice, bob, 7))
+
+			err = bc.AddBlock(block)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("got %v, want %v", err, c.err)
+			}
+
+			wantTip, wantBob := genesis.Hash, 0
+			if c.err == nil {
+				wantTip, wantBob = block.Hash, 7
+			}
+			if !bytes.Equal(bc.tip, wantTip) {
+				t.Errorf("tip is %x, want %x", bc.tip, wantTip)
+			}
+			if got := testBalance(bc, bob); got != wantBob {
+				t.Errorf("bob has %d, want %d", got, wantBob)
+			}
+		})
+	}
+}
+
+// testRemine solves the proof of work again after the header of block
+// was changed
+func testRemine(block *Block) {
+	nonce, hash := NewProofOfWork(&block.BlockHeader).Solve()
+	block.Nonce = nonce
+	block.Hash = hash
+}
+
+func TestCheckBlockSanity(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := NewCoinbaseTX(string(miner.GetAddress()), "", 1, blockSubsidy(1))
+	payment := testPayment(bc, alice, bob, 7)
+	change := testPayment(bc, alice, bob, 8)
+	badID := *payment
+	badID.ID = []byte("payment")
+
+	newBlock := func(txs ...*Transaction) *Block {
+		block := &Block{Transactions: txs}
+		block.BlockHeader = BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: genesis.Hash,
+			MerkleRoot:    block.HashTransaction(),
+			Bits:          params.GenesisBits,
+			Height:        1,
+		}
+		block.Hash = block.BlockHeader.Hash()
+		return block
+	}
+	valid := newBlock(coinbase, payment, change)
+
+	// repeating the last transaction of an odd level keeps the Merkle root
+	mutated := newBlock(coinbase, payment, change)
+	mutated.Transactions = append(mutated.Transactions, change)
+	if !bytes.Equal(mutated.HashTransaction(), valid.MerkleRoot) || !bytes.Equal(mutated.Hash, valid.Hash) {
+		t.Fatal("mutated block does not share the hash of the valid block")
+	}
+
+	badHash := newBlock(coinbase, payment)
+	badHash.Hash = []byte("hash")
+	badRoot := newBlock(coinbase, payment)
+	badRoot.MerkleRoot = []byte("root")
+	badRoot.Hash = badRoot.BlockHeader.Hash()
+
+	cases := []struct {
+		name  string
+		block *Block
+		err   error
+	}{
+		{"valid", valid, nil},
+		{"bad hash", badHash, ErrBadProofOfWork},
+		{"bad transaction ID", newBlock(coinbase, &badID), ErrBadTxID},
+		{"duplicate transaction", newBlock(coinbase, payment, payment), ErrDuplicateTx},
+		{"duplicated last transaction", mutated, ErrDuplicateTx},
+		{"bad Merkle root", badRoot, ErrBadMerkleRoot},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := checkBlockSanity(c.block)
+			if !errors.Is(err, c.err) {
+				t.Errorf("got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestCheckBlockTransactions(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reward := genesis.Transactions[0]
+	minerAddress := string(miner.GetAddress())
+	coinbase := NewCoinbaseTX(minerAddress, "", 1, blockSubsidy(1))
+
+	payment := testPayment(bc, alice, bob, 7)
+	tampered := *payment
+	tampered.Vout = []TXOutput{{50, payment.Vout[0].ScriptPubKey}}
+	tampered.ID = tampered.Hash()
+	unknown := NewCoinbaseTX(string(alice.GetAddress()), "", 1, blockSubsidy(1))
+	withFee := testTransfer(bc, alice, bob, 7, 3, 0)
+	locked := testTransfer(bc, alice, bob, 7, 0, 5)
+	overflow := testSplit(reward, alice, bob, math.MaxInt64/2+1, math.MaxInt64/2+1)
+	splitCoinbase := NewCoinbaseTX(minerAddress, "", 1, maxMoney)
+	splitCoinbase.Vout = append(splitCoinbase.Vout, TXOutput{1, splitCoinbase.Vout[0].ScriptPubKey})
+	splitCoinbase.ID = splitCoinbase.Hash()
+	lockedCoinbase := NewCoinbaseTX(minerAddress, "", 1, blockSubsidy(1))
+	lockedCoinbase.LockTime = 5
+	lockedCoinbase.ID = lockedCoinbase.Hash()
+
+	cases := []struct {
+		name string
+		txs  []*Transaction
+		err  error
+	}{
+		{"valid", []*Transaction{coinbase, payment}, nil},
+		{"fees paid to the miner", []*Transaction{NewCoinbaseTX(minerAddress, "", 1, blockSubsidy(1)+3), withFee}, nil},
+		{"no coinbase", []*Transaction{payment}, ErrNoCoinbase},
+		{"coinbase height", []*Transaction{NewCoinbaseTX(minerAddress, "", 2, blockSubsidy(1))}, ErrBadCoinbaseHeight},
+		{"coinbase value", []*Transaction{NewCoinbaseTX(minerAddress, "", 1, blockSubsidy(1)+1), payment}, ErrBadCoinbaseValue},
+		{"coinbase output above maxMoney", []*Transaction{NewCoinbaseTX(minerAddress, "", 1, maxMoney+1), payment}, ErrBadOutputValue},
+		{"coinbase outputs above maxMoney", []*Transaction{splitCoinbase, payment}, ErrValueOutOfRange},
+		{"coinbase value with fees", []*Transaction{NewCoinbaseTX(minerAddress, "", 1, blockSubsidy(1)+4), withFee}, ErrBadCoinbaseValue},
+		{"second coinbase", []*Transaction{coinbase, NewCoinbaseTX(minerAddress, "", 1, blockSubsidy(1))}, ErrMultipleCoinbase},
+		{"duplicate transaction", []*Transaction{coinbase, payment, payment}, ErrDuplicateTx},
+		{"double spend", []*Transaction{coinbase, payment, testSpend(reward, alice, bob, 8)}, ErrDoubleSpend},
+		{"missing input", []*Transaction{coinbase, testSpend(unknown, alice, bob, 8)}, ErrMissingInput},
+		{"immature coinbase", []*Transaction{coinbase, testSpend(coinbase, miner, bob, 8)}, ErrImmatureCoinbase},
+		{"zero output", []*Transaction{coinbase, testSpend(reward, alice, bob, 0)}, ErrBadOutputValue},
+		{"overflowing outputs", []*Transaction{coinbase, overflow}, ErrBadOutputValue},
+		{"value not conserved", []*Transaction{coinbase, testSpend(reward, alice, bob, 51)}, ErrValueNotConserved},
+		{"lock time", []*Transaction{coinbase, locked}, ErrNonFinalTx},
+		{"coinbase lock time", []*Transaction{lockedCoinbase}, ErrNonFinalTx},
+		{"bad signature", []*Transaction{coinbase, &tampered}, ErrBadSignature},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			block := &Block{Transactions: c.txs}
+			block.BlockHeader = BlockHeader{
+				Version:       blockVersion,
+				PrevBlockHash: genesis.Hash,
+				MerkleRoot:    block.HashTransaction(),
+				Bits:          params.GenesisBits,
+				Height:        1,
+			}
+
+			err := bc.store.View(func(tx StoreTx) error {
+				return checkBlockTransactions(tx, block)
+			})
+			if !errors.Is(err, c.err) {
+				t.Errorf("got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reward := genesis.Transactions[0]
+
+	payment := testPayment(bc, alice, bob, 7)
+	tampered := *payment
+	tampered.Vout = []TXOutput{{50, payment.Vout[0].ScriptPubKey}}
+	tampered.ID = tampered.Hash()
+	input := TXInput{reward.ID, 0, nil, 0}
+	twice := &Transaction{nil, txVersion, []TXInput{input, input}, []TXOutput{*NewTXOutput(8, string(bob.GetAddress()))}, 0}
+	twice.ID = twice.Hash()
+	large := testSpend(reward, alice, bob, 8)
+	large.Vin[0].ScriptSig = make([]byte, maxTxSize)
+	large.ID = large.Hash()
+	noInputs := &Transaction{nil, txVersion, nil, []TXOutput{*NewTXOutput(8, string(bob.GetAddress()))}, 0}
+	noInputs.ID = noInputs.Hash()
+	unknown := NewCoinbaseTX(string(alice.GetAddress()), "", 1, blockSubsidy(1))
+
+	cases := []struct {
+		name string
+		tx   *Transaction
+		err  error
+	}{
+		{"valid", payment, nil},
+		{"coinbase", NewCoinbaseTX(string(alice.GetAddress()), "", 1, blockSubsidy(1)), ErrMultipleCoinbase},
+		{"too large", large, ErrTxTooLarge},
+		{"no inputs", noInputs, ErrMissingInput},
+		{"duplicate input", twice, ErrDuplicateInput},
+		{"missing input", testSpend(unknown, alice, bob, 8), ErrMissingInput},
+		{"zero output", testSpend(reward, alice, bob, 0), ErrBadOutputValue},
+		{"negative output", testSpend(reward, alice, bob, -1), ErrBadOutputValue},
+		{"output above maxMoney", testSpend(reward, alice, bob, maxMoney+1), ErrBadOutputValue},
+		{"outputs above maxMoney", testSplit(reward, alice, bob, maxMoney, 1), ErrValueOutOfRange},
+		{"overflowing outputs", testSplit(reward, alice, bob, math.MaxInt64/2+1, math.MaxInt64/2+1), ErrBadOutputValue},
+		{"value not conserved", testSpend(reward, alice, bob, 51), ErrValueNotConserved},
+		{"wrong key", testSpend(reward, bob, bob, 8), ErrBadSignature},
+		{"bad signature", &tampered, ErrBadSignature},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := bc.CheckTransaction(c.tx)
+			if !errors.Is(err, c.err) {
+				t.Errorf("got %v, want %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	defer func(p *ChainParams) { params = p }(params)
+	slow := *params
+	slow.CoinbaseMaturity = 3
+	params = &slow
+
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reward of block 1 can be spent from block 4 on
+	b1 := newTestBlock(genesis, miner)
+	b2 := newTestBlock(b1, alice)
+	b3 := newTestBlock(b2, alice)
+	reward := b1.Transactions[0]
+	spend := testSpend(reward, miner, bob, reward.Vout[0].Value)
+	for _, block := range []*Block{b1, b2} {
+		err = bc.AddBlock(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = bc.CheckTransaction(spend)
+	if !errors.Is(err, ErrImmatureCoinbase) {
+		t.Errorf("spending at height 3: got %v, want %v", err, ErrImmatureCoinbase)
+	}
+	err = bc.AddBlock(newTestBlock(b2, alice, spend))
+	if !errors.Is(err, ErrImmatureCoinbase) {
+		t.Errorf("block at height 3: got %v, want %v", err, ErrImmatureCoinbase)
+	}
+	spendable, immature := UTXOSet{bc}.GetBalance(payToPubKeyHashScript(HashPubKey(miner.PublicKey)))
+	if spendable != 0 || immature != params.Subsidy {
+		t.Errorf("balance at height 3 is %d spendable and %d immature", spendable, immature)
+	}
+
+	err = bc.AddBlock(b3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spendable, immature = UTXOSet{bc}.GetBalance(payToPubKeyHashScript(HashPubKey(miner.PublicKey)))
+	if spendable != params.Subsidy || immature != 0 {
+		t.Errorf("balance at height 4 is %d spendable and %d immature", spendable, immature)
+	}
+	err = bc.AddBlock(newTestBlock(b3, alice, spend))
+	if err != nil {
+		t.Fatalf("block at height 4: %v", err)
+	}
+	if got := testBalance(bc, bob); got != params.Subsidy {
+		t.Errorf("bob has %d, want %d", got, params.Subsidy)
+	}
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	cases := []struct {
+		height int
+		want   int
+	}{
+		{0, params.Subsidy},
+		{params.HalvingInterval - 1, params.Subsidy},
+		{params.HalvingInterval, params.Subsidy / 2},
+		{2 * params.HalvingInterval, params.Subsidy / 4},
+		{6 * params.HalvingInterval, 0},
+		{64 * params.HalvingInterval, 0},
+		{100 * params.HalvingInterval, 0},
+	}
+
+	for _, c := range cases {
+		if got := blockSubsidy(c.height); got != c.want {
+			t.Errorf("subsidy at height %d is %d, want %d", c.height, got, c.want)
+		}
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	const mtp = lockTimeThreshold + 1000
+	view := newUTXOView(nil, 10, mtp)
+
+	cases := []struct {
+		name     string
+		lockTime int64
+		sequence uint32
+		want     bool
+	}{
+		{"no lock time", 0, 0, true},
+		{"earlier height", 9, 0, true},
+		{"block height", 10, 0, false},
+		{"later height", 11, 0, false},
+		{"highest height", lockTimeThreshold - 1, 0, false},
+		{"earlier time", mtp - 1, 0, true},
+		{"median time past", mtp, 0, false},
+		{"later time", mtp + 1, 0, false},
+		{"final input", 11, sequenceFinal, true},
+		{"final input with time", mtp + 1, sequenceFinal, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tx := &Transaction{LockTime: c.lockTime, Vin: []TXInput{{Sequence: c.sequence}}}
+			if got := view.isFinal(tx); got != c.want {
+				t.Errorf("got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestSequenceLock(t *testing.T) {
+	alice, bob, miner := NewWallet(), NewWallet(), NewWallet()
+	bc := newTestChain(alice)
+	genesis, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reward := genesis.Transactions[0]
+
+	spend := func(sequence uint32) *Transaction {
+		tx := testSpend(reward, alice, bob, 8)
+		tx.Vin[0].Sequence = sequence
+		tx.Vin[0].ScriptSig = nil
+		tx.Sign(alice.PrivateKey, map[string]Transaction{hex.EncodeToString(reward.ID): *reward})
+		tx.ID = tx.Hash()
+		return tx
+	}
+
+	// the reward is checked for a block at height 1 and again at height 2,
+	// after a block stamped 1000 seconds after genesis
+	cases := []struct {
+		name     string
+		sequence uint32
+		first    error
+		second   error
+	}{
+		{"no lock", 0, nil, nil},
+		{"final", sequenceFinal, nil, nil},
+		{"disabled", sequenceLockDisable | 5, nil, nil},
+		{"one block", 1, nil, nil},
+		{"two blocks", 2, ErrSequenceLocked, nil},
+		{"three blocks", 3, ErrSequenceLocked, ErrSequenceLocked},
+		{"512 seconds", sequenceLockTypeTime | 1, ErrSequenceLocked, nil},
+		{"1024 seconds", sequenceLockTypeTime | 2, ErrSequenceLocked, ErrSequenceLocked},
+	}
+
+	for _, c := range cases {
+		err := bc.CheckTransaction(spend(c.sequence))
+		if !errors.Is(err, c.first) {
+			t.Errorf("%s at height 1: got %v, want %v", c.name, err, c.first)
+		}
+	}
+
+	err = bc.AddBlock(newTestBlockAt(genesis, miner, genesis.Timestamp+1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cases {
+		err := bc.CheckTransaction(spend(c.sequence))
+		if !errors.Is(err, c.second) {
+			t.Errorf("%s at height 2: got %v, want %v", c.name, err, c.second)
+		}
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
 
 	"golang.org/x/crypto/ripemd160"
@@ -44,17 +46,97 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pubKey
+	return *private, publicKeyBytes(&private.PublicKey)
+}
+
+// keyCoordinateSize is the length of the coordinates of P-256 points, which
+// public keys and signatures are padded to
+const keyCoordinateSize = 32
+
+// publicKeyBytes returns the X and Y coordinates of pub, which make up the
+// public keys in wallets and scripts
+func publicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	return append(paddedBytes(pub.X, keyCoordinateSize), paddedBytes(pub.Y, keyCoordinateSize)...)
+}
+
+// paddedBytes returns n as a big-endian byte string of at least size bytes
+func paddedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
 }
 
 func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
-	versionedPayload := append([]byte{version}, pubKeyHash...)
+	return encodeAddress(params.AddressVersion, HashPubKey(w.PublicKey))
+}
+
+// MultisigAddress returns the address of outputs that m of the owners of
+// pubKeys have to sign to spend. The address holds the whole multisig
+// script.
+func MultisigAddress(m int, pubKeys [][]byte) []byte {
+	return encodeAddress(params.MultisigAddressVersion, multisigScript(m, pubKeys))
+}
+
+// ScriptHashAddress returns the address of outputs spent by revealing
+// redeemScript and meeting its conditions. It is as short as a wallet
+// address whatever the script.
+func ScriptHashAddress(redeemScript []byte) []byte {
+	return encodeAddress(params.ScriptHashVersion, HashPubKey(redeemScript))
+}
+
+// ValidateAddress checks the checksum of address and that it belongs to
+// the network the program runs on
+func ValidateAddress(address string) bool {
+	_, err := AddressScript(address)
+	return err == nil
+}
+
+var errBadAddress = errors.New("Address is not valid")
+
+// AddressScript returns the locking script of outputs paying to address.
+// The version byte of the address tells what its payload is.
+func AddressScript(address string) ([]byte, error) {
+	version, payload, err := decodeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version == params.AddressVersion && len(payload) == ripemd160.Size:
+		return payToPubKeyHashScript(payload), nil
+	case version == params.ScriptHashVersion && len(payload) == ripemd160.Size:
+		return scriptHashScript(payload), nil
+	case version == params.MultisigAddressVersion:
+		if _, _, ok := extractMultisig(payload); ok {
+			return payload, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", errBadAddress, address)
+}
+
+// encodeAddress returns the Base58 encoding of version, payload and their
+// checksum
+func encodeAddress(version byte, payload []byte) []byte {
+	versionedPayload := append([]byte{version}, payload...)
 	checksum := checksum(versionedPayload)
 	fullPayload := append(versionedPayload, checksum...)
-	address := Base58Encode(fullPayload)
-	return address
+	return Base58Encode(fullPayload)
+}
+
+func decodeAddress(address string) (byte, []byte, error) {
+	fullPayload := Base58Decode([]byte(address))
+	if len(fullPayload) <= 1+addressChecksumLen {
+		return 0, nil, fmt.Errorf("%w: %s", errBadAddress, address)
+	}
+
+	actualChecksum := fullPayload[len(fullPayload)-addressChecksumLen:]
+	versionedPayload := fullPayload[:len(fullPayload)-addressChecksumLen]
+	if !bytes.Equal(actualChecksum, checksum(versionedPayload)) {
+		return 0, nil, fmt.Errorf("%w: %s", errBadAddress, address)
+	}
+	return versionedPayload[0], versionedPayload[1:], nil
 }
 
 func HashPubKey(pubKey []byte) []byte {
@@ -77,11 +159,11 @@ func checksum(payload []byte) []byte {
 }
 
 func (ws *Wallets) LoadFromFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(params.path(walletFile)); os.IsNotExist(err) {
 		return err
 	}
 
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(params.path(walletFile))
 	if err != nil {
 		log.Panic(err)
 	}
@@ -105,7 +187,7 @@ func (ws Wallets) SaveToFile() {
 	if err != nil {
 		log.Panic(err)
 	}
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = ioutil.WriteFile(params.path(walletFile), content.Bytes(), 0644)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAddressScript(t *testing.T) {
+	alice, bob := NewWallet(), NewWallet()
+	multisig := multisigScript(1, [][]byte{alice.PublicKey, bob.PublicKey})
+	payload := Base58Decode(alice.GetAddress())
+	payload[len(payload)-1] ^= 1
+	badChecksum := Base58Encode(payload)
+
+	cases := []struct {
+		name    string
+		address []byte
+		script  []byte
+		err     error
+	}{
+		{"wallet", alice.GetAddress(), payToPubKeyHashScript(HashPubKey(alice.PublicKey)), nil},
+		{"multisig", MultisigAddress(1, [][]byte{alice.PublicKey, bob.PublicKey}), multisig, nil},
+		{"script hash", ScriptHashAddress(multisig), scriptHashScript(HashPubKey(multisig)), nil},
+		{"bad checksum", badChecksum, nil, errBadAddress},
+		{"short", []byte("1"), nil, errBadAddress},
+		{"multisig version with a key hash", encodeAddress(params.MultisigAddressVersion, HashPubKey(alice.PublicKey)), nil, errBadAddress},
+		{"script hash version with a script", encodeAddress(params.ScriptHashVersion, multisig), nil, errBadAddress},
+		{"wallet version with a script", encodeAddress(params.AddressVersion, multisig), nil, errBadAddress},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			script, err := AddressScript(string(c.address))
+			if !errors.Is(err, c.err) || !bytes.Equal(script, c.script) {
+				t.Errorf("got %x, %v, want %x, %v", script, err, c.script, c.err)
+			}
+		})
+	}
+}